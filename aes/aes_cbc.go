@@ -4,6 +4,30 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+)
+
+/*
+AES-CBC + HMAC-SHA2(encrypt-then-MAC), 即 RFC 7518 5.2 的 A128CBC-HS256, A192CBC-HS384 和 A256CBC-HS512:
+	key = macKey | encKey, 32, 48 或 64 字节, 分别对应 AES-128/192/256 和 HMAC-SHA256/384/512
+	tag = HMAC(macKey, additionalData | iv | 密文 | len(additionalData) 的位数(8)) 截断为 len(macKey)
+	输出 iv(16, 随机) | AES-CBC(encKey, iv, PKCS#7 填充的明文) | tag
+先校验 tag 再解密, 校验失败统一返回 ErrAuth, 不会成为 padding oracle
+*/
+
+var (
+	// ErrInvalidPadding PKCS#7 填充错误
+	ErrInvalidPadding = errors.New("invalid padding")
+	// ErrInvalidCBCKey key 长度不是 32, 48 或 64 字节
+	ErrInvalidCBCKey = errors.New("invalid cbc-hmac key size")
 )
 
 // 填充明文
@@ -14,16 +38,35 @@ func pKCS5Padding(plaintext []byte, blockSize int) []byte {
 }
 
 // 去除填充数据
-func pKCS5UnPadding(origData []byte) []byte {
-	length := len(origData)
-	unPadding := int(origData[length-1])
-	if length > unPadding {
-		return origData[:(length - unPadding)]
+func pKCS5UnPadding(origData []byte) ([]byte, error) {
+	data, ok := unpadPKCS7(origData, aes.BlockSize)
+	if !ok {
+		return nil, ErrInvalidPadding
 	}
-	return nil
+	return data, nil
 }
 
-// EncryptCBC AES CBC 加密
+// 校验并去除 PKCS#7 填充, 运行时间与填充内容无关
+func unpadPKCS7(data []byte, blockSize int) ([]byte, bool) {
+	n := len(data)
+	if n == 0 || n%blockSize != 0 {
+		return nil, false
+	}
+	padding := int(data[n-1])
+	good := subtle.ConstantTimeLessOrEq(1, padding) & subtle.ConstantTimeLessOrEq(padding, blockSize)
+	// 最后一块中位于填充范围内的字节都必须等于 padding
+	for i := 1; i <= blockSize; i++ {
+		inPad := subtle.ConstantTimeLessOrEq(i, padding)
+		good &= subtle.ConstantTimeSelect(inPad, subtle.ConstantTimeByteEq(data[n-i], byte(padding)), 1)
+	}
+	if good != 1 {
+		return nil, false
+	}
+	return data[:n-padding], true
+}
+
+// EncryptCBC AES CBC 加密, 没有认证, 仅用于兼容旧系统, 新数据请使用 EncryptCBCHMAC.
+// iv 为空时使用 key 的前 16 字节
 func EncryptCBC(origData, key, iv []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -41,7 +84,7 @@ func EncryptCBC(origData, key, iv []byte) ([]byte, error) {
 	return encrypted, nil
 }
 
-// DecryptCBC AES CBC 解密
+// DecryptCBC AES CBC 解密, 填充错误时返回 ErrInvalidPadding
 func DecryptCBC(encrypted, key, iv []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -50,12 +93,86 @@ func DecryptCBC(encrypted, key, iv []byte) ([]byte, error) {
 
 	// AES分组长度为128位，所以blockSize=16，单位字节
 	blockSize := block.BlockSize()
+	if len(encrypted) == 0 || len(encrypted)%blockSize != 0 {
+		return nil, ErrInvalidPadding
+	}
 	if len(iv) == 0 {
 		iv = key[:blockSize]
 	}
 	blockMode := cipher.NewCBCDecrypter(block, iv)
 	origData := make([]byte, len(encrypted))
 	blockMode.CryptBlocks(origData, encrypted)
-	origData = pKCS5UnPadding(origData)
-	return origData, nil
+	return pKCS5UnPadding(origData)
+}
+
+// EncryptCBCHMAC AES-CBC 加密后计算 HMAC-SHA2, iv 随机生成, 输出 iv | 密文 | tag
+func EncryptCBCHMAC(plaintext, key, additionalData []byte) ([]byte, error) {
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	return sealCBCHMAC(plaintext, key, additionalData, iv)
+}
+
+func sealCBCHMAC(plaintext, key, additionalData, iv []byte) ([]byte, error) {
+	block, mac, err := newCBCHMAC(key)
+	if err != nil {
+		return nil, err
+	}
+	padded := pKCS5Padding(append([]byte(nil), plaintext...), aes.BlockSize)
+	out := make([]byte, aes.BlockSize+len(padded), aes.BlockSize+len(padded)+len(mac.key))
+	copy(out, iv)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out[aes.BlockSize:], padded)
+	return append(out, cbcTag(mac, additionalData, out)...), nil
+}
+
+// DecryptCBCHMAC 校验 tag 后解密 EncryptCBCHMAC 的输出, additionalData 必须与加密时相同
+func DecryptCBCHMAC(encrypted, key, additionalData []byte) ([]byte, error) {
+	block, mac, err := newCBCHMAC(key)
+	if err != nil {
+		return nil, err
+	}
+	n := len(encrypted) - len(mac.key)
+	if n < 2*aes.BlockSize || (n-aes.BlockSize)%aes.BlockSize != 0 {
+		return nil, ErrAuth
+	}
+	if !hmac.Equal(cbcTag(mac, additionalData, encrypted[:n]), encrypted[n:]) {
+		return nil, ErrAuth
+	}
+	plaintext := make([]byte, n-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, encrypted[:aes.BlockSize]).CryptBlocks(plaintext, encrypted[aes.BlockSize:n])
+	return pKCS5UnPadding(plaintext)
+}
+
+type cbcMAC struct {
+	key     []byte
+	newHash func() hash.Hash
+}
+
+func newCBCHMAC(key []byte) (cipher.Block, *cbcMAC, error) {
+	var newHash func() hash.Hash
+	switch len(key) {
+	case 32:
+		newHash = sha256.New
+	case 48:
+		newHash = sha512.New384
+	case 64:
+		newHash = sha512.New
+	default:
+		return nil, nil, ErrInvalidCBCKey
+	}
+	block, err := aes.NewCipher(key[len(key)/2:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, &cbcMAC{key: key[:len(key)/2], newHash: newHash}, nil
+}
+
+// data 为 iv | 密文
+func cbcTag(m *cbcMAC, additionalData, data []byte) []byte {
+	mac := hmac.New(m.newHash, m.key)
+	mac.Write(additionalData)
+	mac.Write(data)
+	_ = binary.Write(mac, binary.BigEndian, uint64(len(additionalData))*8)
+	return mac.Sum(nil)[:len(m.key)]
 }
@@ -0,0 +1,105 @@
+package aes
+
+import (
+	"crypto/aes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// RFC 7518 附录 B.1 - B.3
+func TestEncryptCBCHMAC(t *testing.T) {
+	plaintext := []byte("A cipher system must not be required to be secret, and it must be able to fall into the hands of the enemy without inconvenience")
+	ad := []byte("The second principle of Auguste Kerckhoffs")
+	iv := unhex(t, "1af38c2d c2b96ffd d8669409 2341bc04")
+	for _, c := range []struct {
+		size       int
+		ciphertext string // 密文前 16 字节
+		tag        string
+	}{
+		{32, "c80edfa3 2ddf39d5 ef00c0b4 68834279", "652c3fa3 6b0a7c5b 3219fab3 a30bc1c4"},
+		{48, "ea65da6b 59e61edb 419be62d 19712ae5", "8490ac0e 58949bfe 51875d73 3f93ac20 75168039 ccc733d7"},
+		{64, "4affaaad b78c31c5 da4b1b59 0d10ffbd", "4dd3b4c0 88a7f45c 21683964 5b2012bf 2e6269a8 c56a816d bc1b2677 61955bc5"},
+	} {
+		key := make([]byte, c.size)
+		for i := range key {
+			key[i] = byte(i)
+		}
+		encrypted, err := sealCBCHMAC(plaintext, key, ad, iv)
+		require.NoError(t, err)
+		require.Equal(t, iv, encrypted[:16])
+		require.Equal(t, unhex(t, c.ciphertext), encrypted[16:32])
+		tag := unhex(t, c.tag)
+		require.Equal(t, tag, encrypted[len(encrypted)-len(tag):])
+		got, err := DecryptCBCHMAC(encrypted, key, ad)
+		require.NoError(t, err)
+		require.Equal(t, plaintext, got)
+	}
+
+	for _, size := range []int{32, 48, 64} {
+		key, err := GenAesKey(size)
+		require.NoError(t, err)
+		for _, n := range []int{0, 1, 15, 16, 17, 100} {
+			plaintext := make([]byte, n)
+			a, err := EncryptCBCHMAC(plaintext, key, nil)
+			require.NoError(t, err)
+			b, err := EncryptCBCHMAC(plaintext, key, nil)
+			require.NoError(t, err)
+			require.NotEqual(t, a[:aes.BlockSize], b[:aes.BlockSize])
+			got, err := DecryptCBCHMAC(a, key, nil)
+			require.NoError(t, err)
+			require.Equal(t, plaintext, got)
+
+			// 修改任意字节都无法通过认证
+			for i := range a {
+				a[i] ^= 1
+				_, err = DecryptCBCHMAC(a, key, nil)
+				require.ErrorIs(t, err, ErrAuth)
+				a[i] ^= 1
+			}
+			_, err = DecryptCBCHMAC(a, key, []byte("ad"))
+			require.ErrorIs(t, err, ErrAuth)
+			_, err = DecryptCBCHMAC(a[:len(a)-1], key, nil)
+			require.ErrorIs(t, err, ErrAuth)
+		}
+	}
+	_, err := EncryptCBCHMAC(plaintext, make([]byte, 16), nil)
+	require.ErrorIs(t, err, ErrInvalidCBCKey)
+}
+
+func TestUnpadPKCS7(t *testing.T) {
+	block := func(tail ...byte) []byte {
+		b := make([]byte, 16)
+		copy(b[16-len(tail):], tail)
+		return b
+	}
+	for _, c := range []struct {
+		data []byte
+		n    int
+	}{
+		{block(1), 15},
+		{block(2, 2), 14},
+		{block(9, 3, 3, 3), 13},
+		{append(make([]byte, 16), block(16, 16, 16, 16, 16, 16, 16, 16, 16, 16, 16, 16, 16, 16, 16, 16)...), 16},
+	} {
+		got, err := pKCS5UnPadding(c.data)
+		require.NoError(t, err)
+		require.Len(t, got, c.n)
+	}
+	for _, data := range [][]byte{nil, make([]byte, 15), block(0), block(17), block(1, 2), block(3, 2, 3, 3), block(3, 3, 2)} {
+		_, err := pKCS5UnPadding(data)
+		require.ErrorIs(t, err, ErrInvalidPadding, data)
+	}
+
+	// 旧的 CBC 接口在填充错误时返回错误
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	encrypted, err := EncryptCBC([]byte("hello"), key, nil)
+	require.NoError(t, err)
+	_, err = DecryptCBC(encrypted, key, make([]byte, 16))
+	require.ErrorIs(t, err, ErrInvalidPadding)
+	_, err = DecryptCBC(encrypted[:15], key, nil)
+	require.ErrorIs(t, err, ErrInvalidPadding)
+}
@@ -0,0 +1,189 @@
+package aes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+/*
+分块认证加密(AES-256-GCM):
+	明文按 ChunkSize 分块, 每块单独加密并带 16 字节 tag
+	nonce = 0(3) | 序号(8, BE) | 最后一块标志(1)
+	块密钥 = HKDF-SHA256(内容密钥, Salt, "hxaes chunk" | 文件头)
+
+篡改、重排、截断或追加数据都会导致解密失败.
+*/
+
+// ErrAuth 数据认证失败, 文件被篡改或密钥错误
+var ErrAuth = errors.New("authentication failed")
+
+var errClosed = errors.New("write after close")
+
+// DefaultChunkSize 默认分块大小
+const DefaultChunkSize = 64 * 1024
+
+const maxChunkSize = 16 * 1024 * 1024
+
+// 生成块加密使用的 AEAD, 文件头中除被加密的内容密钥外的字段都参与密钥派生
+func newChunkAEAD(key []byte, h *Header) (cipher.AEAD, error) {
+	if len(h.Salt) == 0 || h.ChunkSize == 0 || h.ChunkSize > maxChunkSize {
+		return nil, ErrInvalidHeader
+	}
+	aad, err := h.authData()
+	if err != nil {
+		return nil, err
+	}
+	subKey := make([]byte, 32)
+	kdf := hkdf.New(sha256.New, key, h.Salt, append([]byte("hxaes chunk"), aad...))
+	if _, err = io.ReadFull(kdf, subKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(subKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(nonce []byte, seq uint64, last bool) []byte {
+	binary.BigEndian.PutUint64(nonce[3:11], seq)
+	nonce[11] = 0
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// chunkWriter 分块加密, Close 时写入最后一块
+type chunkWriter struct {
+	w         io.Writer
+	aead      cipher.AEAD
+	chunkSize int
+	buf       []byte
+	out       []byte
+	nonce     []byte
+	seq       uint64
+	err       error
+}
+
+func newChunkWriter(w io.Writer, aead cipher.AEAD, chunkSize int) *chunkWriter {
+	return &chunkWriter{
+		w:         w,
+		aead:      aead,
+		chunkSize: chunkSize,
+		buf:       make([]byte, 0, chunkSize),
+		out:       make([]byte, 0, chunkSize+aead.Overhead()),
+		nonce:     make([]byte, aead.NonceSize()),
+	}
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	var n int
+	for len(p) > 0 {
+		// 缓冲已满且还有数据, 说明不是最后一块
+		if len(c.buf) == c.chunkSize {
+			if c.err = c.flush(false); c.err != nil {
+				return n, c.err
+			}
+		}
+		m := copy(c.buf[len(c.buf):c.chunkSize], p)
+		c.buf = c.buf[:len(c.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+// Close 写入最后一块, 不会关闭底层 writer
+func (c *chunkWriter) Close() error {
+	if c.err != nil {
+		return c.err
+	}
+	if err := c.flush(true); err != nil {
+		c.err = err
+		return err
+	}
+	c.err = errClosed
+	return nil
+}
+
+func (c *chunkWriter) flush(last bool) error {
+	c.out = c.aead.Seal(c.out[:0], chunkNonce(c.nonce, c.seq, last), c.buf, nil)
+	if _, err := c.w.Write(c.out); err != nil {
+		return err
+	}
+	c.seq++
+	c.buf = c.buf[:0]
+	return nil
+}
+
+// chunkReader 分块解密
+type chunkReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	buf   []byte // 多读一个字节, 用于判断是否为最后一块
+	n     int
+	plain []byte
+	out   []byte
+	nonce []byte
+	seq   uint64
+	err   error
+}
+
+func newChunkReader(r io.Reader, aead cipher.AEAD, chunkSize int) *chunkReader {
+	return &chunkReader{
+		r:     r,
+		aead:  aead,
+		buf:   make([]byte, chunkSize+aead.Overhead()+1),
+		out:   make([]byte, 0, chunkSize),
+		nonce: make([]byte, aead.NonceSize()),
+	}
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.plain) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.next()
+	}
+	n := copy(p, c.plain)
+	c.plain = c.plain[n:]
+	return n, nil
+}
+
+func (c *chunkReader) next() error {
+	n, err := io.ReadFull(c.r, c.buf[c.n:])
+	c.n += n
+	last := false
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		last = true
+	} else if err != nil {
+		return err
+	}
+
+	encLen := c.n
+	if !last {
+		encLen = len(c.buf) - 1
+	}
+	plain, err := c.aead.Open(c.out[:0], chunkNonce(c.nonce, c.seq, last), c.buf[:encLen], nil)
+	if err != nil {
+		return ErrAuth
+	}
+	c.plain = plain
+	c.seq++
+	if last {
+		return io.EOF
+	}
+	c.n = copy(c.buf, c.buf[encLen:c.n])
+	return nil
+}
@@ -0,0 +1,91 @@
+package aes
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func chunkEncrypt(t *testing.T, h *Header, key, data []byte) []byte {
+	aead, err := newChunkAEAD(key, h)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := newChunkWriter(&buf, aead, int(h.ChunkSize))
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func chunkDecrypt(h *Header, key, enc []byte) ([]byte, error) {
+	aead, err := newChunkAEAD(key, h)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(newChunkReader(bytes.NewReader(enc), aead, int(h.ChunkSize)))
+}
+
+func TestChunk(t *testing.T) {
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+	h := &Header{Version: Version1, Cipher: CipherAES256GCMChunk, Salt: []byte("salt"), ChunkSize: 16}
+
+	for _, size := range []int{0, 1, 15, 16, 17, 48, 50} {
+		data := bytes.Repeat([]byte{'a'}, size)
+		enc := chunkEncrypt(t, h, key, data)
+		dec, err := chunkDecrypt(h, key, enc)
+		require.NoError(t, err)
+		require.Equal(t, data, dec)
+	}
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 3)
+	enc := chunkEncrypt(t, h, key, data)
+	encChunk := 16 + 16
+
+	// 篡改
+	tampered := append([]byte{}, enc...)
+	tampered[encChunk+1] ^= 1
+	_, err = chunkDecrypt(h, key, tampered)
+	require.ErrorIs(t, err, ErrAuth)
+
+	// 在块边界截断
+	_, err = chunkDecrypt(h, key, enc[:2*encChunk])
+	require.ErrorIs(t, err, ErrAuth)
+
+	// 追加数据
+	_, err = chunkDecrypt(h, key, append(append([]byte{}, enc...), 0))
+	require.ErrorIs(t, err, ErrAuth)
+
+	// 交换块
+	reordered := append([]byte{}, enc[encChunk:2*encChunk]...)
+	reordered = append(reordered, enc[:encChunk]...)
+	reordered = append(reordered, enc[2*encChunk:]...)
+	_, err = chunkDecrypt(h, key, reordered)
+	require.ErrorIs(t, err, ErrAuth)
+
+	// 文件头被修改
+	h2 := *h
+	h2.ChunkSize = 17
+	_, err = chunkDecrypt(&h2, key, enc)
+	require.ErrorIs(t, err, ErrAuth)
+}
+
+func TestEncryptFileTampered(t *testing.T) {
+	dir := t.TempDir()
+	in, out, dec := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"), filepath.Join(dir, "dec.txt")
+	data := bytes.Repeat([]byte("hello world"), 20000)
+	require.NoError(t, os.WriteFile(in, data, 0600))
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+
+	require.NoError(t, EncryptFile(in, out, key, false))
+	enc, err := os.ReadFile(out)
+	require.NoError(t, err)
+	enc[len(enc)/2] ^= 1
+	require.NoError(t, os.WriteFile(out, enc, 0600))
+	require.ErrorIs(t, DecryptFile(out, dec, key, false), ErrAuth)
+}
@@ -1,424 +1,204 @@
 package aes
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
+	"context"
 	"crypto/rsa"
 	"errors"
 	"io"
 	"os"
-	"path/filepath"
+
+	"github.com/happyxhw/pkg/fsutil"
 )
 
 const bufLen = 32 * 1024
 
-// EncryptFile encrypt file
-func EncryptFile(in, out string, key []byte, fixedIV bool) error {
-	inFile, err := os.Open(in)
-	if err != nil {
-		return err
-	}
-	defer inFile.Close()
+// ErrSameFile 输入和输出不能是同一个文件
+var ErrSameFile = errors.New("in and out must be different")
 
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return err
-	}
-	var iv []byte
-	if fixedIV {
-		iv = key[:block.BlockSize()]
-	} else {
-		iv = make([]byte, block.BlockSize())
-		_, err = io.ReadFull(rand.Reader, iv)
-		if err != nil {
-			return err
-		}
-	}
+// 返回设置了 KDF 及密钥相关字段的文件头和内容密钥
+type headerFunc func() (*Header, []byte, error)
 
-	outFile, err := os.OpenFile(out, os.O_RDWR|os.O_CREATE, 0755)
-	if err != nil {
-		return err
-	}
-	defer outFile.Close()
-
-	buf := make([]byte, bufLen)
-	stream := cipher.NewCTR(block, iv)
-	for {
-		n, err := inFile.Read(buf)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-		stream.XORKeyStream(buf, buf[:n])
-		if _, wErr := outFile.Write(buf[:n]); wErr != nil {
-			return wErr
-		}
-	}
-	if !fixedIV {
-		if _, wErr := outFile.Write(iv); wErr != nil {
-			return wErr
-		}
-	}
+// EncryptFile encrypt file, 使用分块 AES-GCM.
+//
+// Deprecated: fixedIV 被忽略, 新文件总是使用随机 salt, 请使用 EncryptFileContext.
+// 解密旧格式文件时 DecryptFile 的 fixedIV 仍然有效
+func EncryptFile(in, out string, key []byte, fixedIV bool, opts ...Opt) error {
+	return EncryptFileContext(context.Background(), in, out, key, opts...)
+}
 
-	return nil
+// EncryptFileContext 同 EncryptFile, 每次读取前检查 ctx, 取消后返回 ctx.Err() 且不产生输出文件
+func EncryptFileContext(ctx context.Context, in, out string, key []byte, opts ...Opt) error {
+	return encryptFile(ctx, in, out, getOpt(opts...), rawHeader(key))
 }
 
-func DecryptFile(in, out string, key []byte, fixedIV bool) error {
-	inFile, err := os.Open(in)
-	if err != nil {
-		return err
-	}
-	defer inFile.Close()
+func DecryptFile(in, out string, key []byte, fixedIV bool, opts ...Opt) error {
+	_, err := DecryptAny(in, out, &Keys{Key: key, FixedIV: fixedIV}, opts...)
+	return err
+}
 
-	fi, err := inFile.Stat()
-	if err != nil {
-		return err
-	}
+func EncryptFileWithRSA(in, out string, keyKey []byte, pk *rsa.PublicKey, opts ...Opt) error {
+	return EncryptFileWithRSAContext(context.Background(), in, out, keyKey, pk, opts...)
+}
 
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return err
-	}
-	var iv []byte
-	fileLen := fi.Size()
-	if fixedIV {
-		iv = key[:block.BlockSize()]
-	} else {
-		iv = make([]byte, block.BlockSize())
-		fileLen -= int64(len(iv))
-		_, err = inFile.ReadAt(iv, fileLen)
-		if err != nil {
-			return err
-		}
-	}
+func EncryptFileWithRSAContext(ctx context.Context, in, out string, keyKey []byte, pk *rsa.PublicKey, opts ...Opt) error {
+	opt := getOpt(opts...)
+	return encryptFile(ctx, in, out, opt, func() (*Header, []byte, error) {
+		return newRSAHeader(keyKey, pk, opt.OAEP)
+	})
+}
 
-	outFile, err := os.OpenFile(out, os.O_RDWR|os.O_CREATE, 0755)
-	if err != nil {
-		return err
-	}
-	defer outFile.Close()
+func DecryptFileWithRSA(in, out string, keyKey []byte, pk *rsa.PrivateKey, opts ...Opt) error {
+	_, err := DecryptAny(in, out, &Keys{KeyKey: keyKey, PrivateKey: pk}, opts...)
+	return err
+}
 
-	buf := make([]byte, bufLen)
-	stream := cipher.NewCTR(block, iv)
-	remainingLen := fileLen
-	for {
-		if remainingLen <= 0 {
-			break
-		}
-		n, err := inFile.Read(buf)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-		if !fixedIV {
-			if n > int(remainingLen) {
-				n = int(remainingLen)
-			}
-			remainingLen -= int64(n)
-		}
-		stream.XORKeyStream(buf, buf[:n])
-		if _, wErr := outFile.Write(buf[:n]); wErr != nil {
-			return wErr
-		}
-	}
-	return nil
+func EncryptFileAndPathWithRSA(in, out string, keyKey []byte, pk *rsa.PublicKey, opts ...Opt) error {
+	return EncryptFileAndPathWithRSAContext(context.Background(), in, out, keyKey, pk, opts...)
 }
 
-func EncryptFileWithRSA(in, out string, keyKey []byte, pk *rsa.PublicKey) error {
-	// 随机生成 key 和 iv
-	key, err := GenAesKey(32)
-	if err != nil {
-		return err
-	}
+func EncryptFileAndPathWithRSAContext(ctx context.Context, in, out string, keyKey []byte, pk *rsa.PublicKey, opts ...Opt) error {
+	opt := getOpt(opts...)
+	opt.Path = in
+	return encryptFile(ctx, in, out, opt, func() (*Header, []byte, error) {
+		return newRSAHeader(keyKey, pk, opt.OAEP)
+	})
+}
 
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return err
-	}
-	iv, err := GenAesKey(block.BlockSize())
-	if err != nil {
-		return err
-	}
+// DecryptFileAndPathWithRSA out 为空时解密到文件头中的路径, 同 DecryptAny. 恢复目录请使用 DecryptDir
+func DecryptFileAndPathWithRSA(in, out string, keyKey []byte, pk *rsa.PrivateKey, opts ...Opt) (string, error) {
+	return DecryptAny(in, out, &Keys{KeyKey: keyKey, PrivateKey: pk}, opts...)
+}
 
-	inFile, err := os.Open(in)
-	if err != nil {
-		return err
-	}
-	defer inFile.Close()
+// EncryptFileWithPassword 从口令派生 key 加密文件, 派生参数写入文件头
+func EncryptFileWithPassword(in, out string, password []byte, opts ...Opt) error {
+	return EncryptFileWithPasswordContext(context.Background(), in, out, password, opts...)
+}
 
-	outFile, err := os.OpenFile(out, os.O_RDWR|os.O_CREATE, 0755)
-	if err != nil {
-		return err
-	}
-	defer outFile.Close()
+func EncryptFileWithPasswordContext(ctx context.Context, in, out string, password []byte, opts ...Opt) error {
+	opt := getOpt(opts...)
+	return encryptFile(ctx, in, out, opt, func() (*Header, []byte, error) {
+		return newPasswordHeader(password, opt.KDFParams)
+	})
+}
 
-	tmp := make([]byte, 0, len(key)+len(iv))
-	tmp = append(tmp, key...)
-	tmp = append(tmp, iv...)
-	// aes 加密 key iv
-	tmp, err = EncryptGCM(tmp, keyKey)
-	if err != nil {
-		return err
-	}
-	// rsa 加密 key iv
-	encTmp, err := RsaEncrypt(tmp, pk)
-	if err != nil {
-		return err
-	}
-	var head []byte
-	n := len(encTmp)
-	head = append(head, byte(n), byte(n>>8))
-	head = append(head, encTmp...)
-	_, err = outFile.Write(head)
-	if err != nil {
-		return err
-	}
+func DecryptFileWithPassword(in, out string, password []byte, opts ...Opt) error {
+	_, err := DecryptAny(in, out, &Keys{Password: password}, opts...)
+	return err
+}
 
-	buf := make([]byte, bufLen)
-	stream := cipher.NewCTR(block, iv)
-	for {
-		n, err := inFile.Read(buf)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-		stream.XORKeyStream(buf, buf[:n])
-		if _, wErr := outFile.Write(buf[:n]); wErr != nil {
-			return wErr
-		}
-	}
+// DecryptAny 根据文件头选择解密方式, 没有文件头时按旧格式解密.
+// 文件中包含原始路径且 out 为空时解密到原始路径, 返回文件中的原始路径(如有).
+// 原始路径是绝对路径或在当前目录之外时返回 ErrUnsafePath, 此时需要指定 out.
+// opts 中仅 Perm, NoOverwrite 和 Progress 有效
+func DecryptAny(in, out string, keys *Keys, opts ...Opt) (string, error) {
+	return DecryptAnyContext(context.Background(), in, out, keys, opts...)
+}
 
-	return nil
+// DecryptAnyContext 同 DecryptAny, 每次读取前检查 ctx, 取消后返回 ctx.Err() 且不产生输出文件
+func DecryptAnyContext(ctx context.Context, in, out string, keys *Keys, opts ...Opt) (string, error) {
+	return decryptAny(ctx, in, out, keys, getOpt(opts...))
 }
 
-func DecryptFileWithRSA(in, out string, keyKey []byte, pk *rsa.PrivateKey) error {
+func decryptAny(ctx context.Context, in, out string, keys *Keys, opt Opt) (string, error) {
 	inFile, err := os.Open(in)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer inFile.Close()
 
-	tmp := make([]byte, bufLen)
-	_, err = io.ReadFull(inFile, tmp[:2])
-	if err != nil {
-		return err
-	}
-	n := int(tmp[0]) | int(tmp[1])<<8
-	if n > bufLen {
-		return errors.New("len(rsa) out of index")
-	}
-	_, err = io.ReadFull(inFile, tmp[:n])
-	if err != nil {
-		return err
+	// 多个分片时并发解密
+	if h, err2 := ReadHeader(inFile); err2 == nil && h.Part != nil && h.Part.Count > 1 {
+		return decryptParts(ctx, inFile, h, out, keys, opt)
 	}
-	// rsa 解密
-	keyIV, err := RsaDecrypt(tmp[:n], pk)
-	if err != nil {
-		return err
+	if _, err = inFile.Seek(0, io.SeekStart); err != nil {
+		return "", err
 	}
-	// aes 解密
-	keyIV, err = DecryptGCM(keyIV, keyKey)
+	fi, err := inFile.Stat()
 	if err != nil {
-		return err
+		return "", err
 	}
-	key, iv := keyIV[:32], keyIV[32:]
-	block, err := aes.NewCipher(key)
+
+	r, err := newDecryptReader(ctx, fsutil.NewProgress(ctx, fi.Size(), opt.Progress).Reader(inFile), keys)
 	if err != nil {
-		return err
+		return "", err
 	}
+	return r.Path(), decryptTo(out, r.Path(), opt, r)
+}
 
-	outFile, err := os.OpenFile(out, os.O_RDWR|os.O_CREATE, 0755)
+// opt.SplitSize 大于 0 时分片并发加密
+func encryptFile(ctx context.Context, in, out string, opt Opt, newHeader headerFunc) error {
+	inFile, err := os.Open(in)
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
+	defer inFile.Close()
 
-	buf := make([]byte, bufLen)
-	stream := cipher.NewCTR(block, iv)
-	for {
-		n, err := inFile.Read(buf)
-		if err == io.EOF {
-			break
-		}
+	if opt.Compress != CodecNone {
+		ok, err := shouldCompress(inFile)
 		if err != nil {
 			return err
 		}
-		stream.XORKeyStream(buf, buf[:n])
-		if _, wErr := outFile.Write(buf[:n]); wErr != nil {
-			return wErr
+		if !ok {
+			opt.Compress = CodecNone
+		} else if opt.SplitSize > 0 {
+			return ErrCompressParts
 		}
 	}
-	return nil
-}
-
-func EncryptFileAndPathWithRSA(in, out string, keyKey []byte, pk *rsa.PublicKey) error {
-	// 随机生成 key 和 iv
-	key, err := GenAesKey(32)
-	if err != nil {
-		return err
-	}
-
-	block, err := aes.NewCipher(key)
+	fi, err := inFile.Stat()
 	if err != nil {
 		return err
 	}
-	iv, err := GenAesKey(block.BlockSize())
-	if err != nil {
-		return err
+	if opt.SplitSize > 0 {
+		return encryptParts(ctx, inFile, fi.Size(), out, opt, newHeader)
 	}
 
-	inFile, err := os.Open(in)
-	if err != nil {
-		return err
+	r := fsutil.NewProgress(ctx, fi.Size(), opt.Progress).Reader(inFile)
+	return encryptTo(out, opt, r, func(w io.Writer) (io.WriteCloser, error) {
+		h, key, err := newHeader()
+		if err != nil {
+			return nil, err
+		}
+		return newEncryptWriter(w, h, key, opt)
+	})
+}
+
+func rawHeader(key []byte) headerFunc {
+	return func() (*Header, []byte, error) {
+		return &Header{KDF: KDFRaw}, key, nil
 	}
-	defer inFile.Close()
+}
 
-	outFile, err := os.OpenFile(out, os.O_RDWR|os.O_CREATE, 0755)
+// 加密 r 中的数据写入 out
+func encryptTo(out string, opt Opt, r io.Reader, newWriter WriterFunc) error {
+	outFile, err := openOutput(out, opt)
 	if err != nil {
 		return err
 	}
 	defer outFile.Close()
 
-	tmp := make([]byte, 0, len(key)+len(iv))
-	tmp = append(tmp, key...)
-	tmp = append(tmp, iv...)
-	// aes 加密 key iv
-	tmp, err = EncryptGCM(tmp, keyKey)
-	if err != nil {
-		return err
-	}
-	// rsa 加密 key iv
-	encTmp, err := RsaEncrypt(tmp, pk)
-	if err != nil {
-		return err
-	}
-	var head []byte
-	n := len(encTmp)
-	head = append(head, byte(n), byte(n>>8))
-	head = append(head, encTmp...)
-	_, err = outFile.Write(head)
+	w, err := newWriter(outFile)
 	if err != nil {
 		return err
 	}
-
-	var pathHead []byte
-	encPath, err := EncryptGCM([]byte(in), keyKey)
-	if err != nil {
+	if _, err = io.CopyBuffer(w, r, make([]byte, bufLen)); err != nil {
 		return err
 	}
-	n = len(encPath)
-	pathHead = append(pathHead, byte(n), byte(n>>8))
-	pathHead = append(pathHead, encPath...)
-	_, err = outFile.Write(pathHead)
-	if err != nil {
+	if err = w.Close(); err != nil {
 		return err
 	}
-
-	buf := make([]byte, bufLen)
-	stream := cipher.NewCTR(block, iv)
-	for {
-		n, err := inFile.Read(buf)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-		stream.XORKeyStream(buf, buf[:n])
-		if _, wErr := outFile.Write(buf[:n]); wErr != nil {
-			return wErr
-		}
-	}
-
-	return nil
+	return outFile.Commit()
 }
 
-func DecryptFileAndPathWithRSA(in, out string, keyKey []byte, pk *rsa.PrivateKey) (string, error) {
-	inFile, err := os.Open(in)
-	if err != nil {
-		return "", err
-	}
-	defer inFile.Close()
-
-	tmp := make([]byte, bufLen)
-	_, err = io.ReadFull(inFile, tmp[:2])
+// 写入解密数据, out 为空时写入文件中的原始路径
+func decryptTo(out, path string, opt Opt, r io.Reader) error {
+	outFile, err := createOutput(out, path, opt)
 	if err != nil {
-		return "", err
-	}
-	n := int(tmp[0]) | int(tmp[1])<<8
-	if n > bufLen {
-		return "", errors.New("len(rsa) out of index")
-	}
-	_, err = io.ReadFull(inFile, tmp[:n])
-	if err != nil {
-		return "", err
-	}
-	// rsa 解密
-	keyIV, err := RsaDecrypt(tmp[:n], pk)
-	if err != nil {
-		return "", err
-	}
-	// aes 解密
-	keyIV, err = DecryptGCM(keyIV, keyKey)
-	if err != nil {
-		return "", err
-	}
-	key, iv := keyIV[:32], keyIV[32:]
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return "", err
-	}
-
-	tmp = make([]byte, 2)
-	_, err = io.ReadFull(inFile, tmp[:2])
-	if err != nil {
-		return "", err
-	}
-	n = int(tmp[0]) | int(tmp[1])<<8
-	pathEnc := make([]byte, n)
-	_, err = io.ReadFull(inFile, pathEnc[:n])
-	if err != nil {
-		return "", err
-	}
-	pathDec, err := DecryptGCM(pathEnc, keyKey)
-	if err != nil {
-		return "", err
-	}
-	if out == "" {
-		out = string(pathDec)
-		dir := filepath.Dir(out)
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return "", err
-		}
-	}
-
-	outFile, err := os.OpenFile(out, os.O_RDWR|os.O_CREATE, 0755)
-	if err != nil {
-		return "", err
+		return err
 	}
 	defer outFile.Close()
 
-	buf := make([]byte, bufLen)
-	stream := cipher.NewCTR(block, iv)
-	for {
-		n, err := inFile.Read(buf)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return "", err
-		}
-		stream.XORKeyStream(buf, buf[:n])
-		if _, wErr := outFile.Write(buf[:n]); wErr != nil {
-			return "", wErr
-		}
+	if _, err = io.CopyBuffer(outFile, r, make([]byte, bufLen)); err != nil {
+		return err
 	}
-	return string(pathDec), nil
+	return outFile.Commit()
 }
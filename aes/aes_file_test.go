@@ -1,22 +1,29 @@
 package aes
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
 func TestEncryptFile(t *testing.T) {
+	dir := t.TempDir()
+	out, dec := filepath.Join(dir, "out.txt"), filepath.Join(dir, "in_2.txt")
 	key := []byte("cQfTjWnZr4u7x!A%D*G-KaPdRgUkXp2s")
-	err := EncryptFile("./aes_file.go", "./out.txt", key, true)
+	err := EncryptFileContext(context.Background(), "./aes_file.go", out, key)
 	require.NoError(t, err)
 
-	err = DecryptFile("./out.txt", "./in_2.txt", key, true)
+	err = DecryptFile(out, dec, key, false)
 	require.NoError(t, err)
 }
 
 func TestEncryptFileWithRSA(t *testing.T) {
-	privatePath, publicPath := "./id_rsa", "./id_rsa.pub"
+	dir := t.TempDir()
+	out, dec := filepath.Join(dir, "out.txt"), filepath.Join(dir, "in_2.txt")
+	privatePath, publicPath := filepath.Join(dir, "id_rsa"), filepath.Join(dir, "id_rsa.pub")
 	require.NoError(t, GenRsaPrivateKey(4096, privatePath, nil))
 	require.NoError(t, GenRsaPublicKey(4096, privatePath, publicPath, nil))
 
@@ -26,15 +33,17 @@ func TestEncryptFileWithRSA(t *testing.T) {
 	require.NoError(t, err)
 
 	key := []byte("cQfTjWnZr4u7x!A%D*G-KaPdRgUkXp2s")
-	err = EncryptFileWithRSA("./aes_file.go", "./out.txt", key, publicKey)
+	err = EncryptFileWithRSA("./aes_file.go", out, key, publicKey)
 	require.NoError(t, err)
 
-	err = DecryptFileWithRSA("./out.txt", "./in_2.txt", key, privateKey)
+	err = DecryptFileWithRSA(out, dec, key, privateKey)
 	require.NoError(t, err)
 }
 
 func TestEncryptFileAndPathWithRSA(t *testing.T) {
-	privatePath, publicPath := "./id_rsa", "./id_rsa.pub"
+	dir := t.TempDir()
+	in, out, dec := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"), filepath.Join(dir, "dec.txt")
+	privatePath, publicPath := filepath.Join(dir, "id_rsa"), filepath.Join(dir, "id_rsa.pub")
 	require.NoError(t, GenRsaPrivateKey(4096, privatePath, nil))
 	require.NoError(t, GenRsaPublicKey(4096, privatePath, publicPath, nil))
 
@@ -43,11 +52,105 @@ func TestEncryptFileAndPathWithRSA(t *testing.T) {
 	publicKey, err := ReadPublicKey(publicPath)
 	require.NoError(t, err)
 
+	require.NoError(t, os.WriteFile(in, []byte("hello world"), 0600))
+
 	key := []byte("cQfTjWnZr4u7x!A%D*G-KaPdRgUkXp2s")
-	err = EncryptFileAndPathWithRSA("./in.txt", "./out.txt", key, publicKey)
+	err = EncryptFileAndPathWithRSA(in, out, key, publicKey)
+	require.NoError(t, err)
+
+	path, err := DecryptFileAndPathWithRSA(out, dec, key, privateKey)
+	require.NoError(t, err)
+	require.Equal(t, path, in)
+	data, err := os.ReadFile(dec)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(data))
+
+	// 文件头中的路径由加密方决定, out 为空时不能写到当前目录之外
+	_, err = DecryptFileAndPathWithRSA(out, "", key, privateKey)
+	require.ErrorIs(t, err, ErrUnsafePath)
+	for _, p := range []string{"../evil", "a/../../evil", "/tmp/evil"} {
+		require.NoError(t, EncryptFileContext(context.Background(), in, out, key, Opt{Path: p}))
+		_, err = DecryptAny(out, "", &Keys{Key: key})
+		require.ErrorIs(t, err, ErrUnsafePath, p)
+	}
+}
+
+func TestSafeOutput(t *testing.T) {
+	dir := t.TempDir()
+	in, out, dec := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"), filepath.Join(dir, "dec.txt")
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(in, []byte("hello world"), 0600))
+
+	// 覆盖更长的已有文件, 不会残留旧数据
+	require.NoError(t, os.WriteFile(out, make([]byte, 1024), 0644))
+	require.NoError(t, os.WriteFile(dec, make([]byte, 1024), 0644))
+	require.NoError(t, EncryptFile(in, out, key, false))
+	require.NoError(t, DecryptFile(out, dec, key, false))
+	requireFile(t, dec, []byte("hello world"))
+	fi, err := os.Stat(dec)
 	require.NoError(t, err)
+	require.Equal(t, defaultPerm, fi.Mode().Perm())
 
-	path, err := DecryptFileAndPathWithRSA("./out.txt", "", key, privateKey)
+	require.NoError(t, DecryptFile(out, dec, key, false, Opt{Perm: 0640}))
+	fi, err = os.Stat(dec)
 	require.NoError(t, err)
-	require.Equal(t, path, "./in.txt")
+	require.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+
+	// 不覆盖已有文件
+	err = EncryptFile(in, out, key, false, Opt{NoOverwrite: true})
+	require.ErrorIs(t, err, os.ErrExist)
+	err = DecryptFile(out, dec, key, false, Opt{NoOverwrite: true})
+	require.ErrorIs(t, err, os.ErrExist)
+	require.NoError(t, DecryptFile(out, filepath.Join(dir, "new.txt"), key, false, Opt{NoOverwrite: true}))
+
+	// 失败时不改变已有文件, 也不留下临时文件
+	wrong, err := GenAesKey(32)
+	require.NoError(t, err)
+	require.Error(t, DecryptFile(out, dec, wrong, false))
+	requireFile(t, dec, []byte("hello world"))
+	require.Error(t, DecryptFile(out, filepath.Join(dir, "missing.txt"), wrong, false))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	require.Equal(t, []string{"dec.txt", "in.txt", "new.txt", "out.txt"}, names)
+}
+
+func TestEncryptFileContext(t *testing.T) {
+	dir := t.TempDir()
+	in, out, dec := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"), filepath.Join(dir, "dec.txt")
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+	data := make([]byte, 1<<20)
+	require.NoError(t, os.WriteFile(in, data, 0600))
+
+	for _, splitSize := range []int64{0, 256 * 1024} {
+		var last, total int64
+		progress := func(done, n int64) {
+			require.Greater(t, done, last)
+			last, total = done, n
+		}
+		opt := Opt{SplitSize: splitSize, Progress: progress}
+		require.NoError(t, EncryptFileContext(context.Background(), in, out, key, opt))
+		require.Equal(t, int64(len(data)), last)
+		require.Equal(t, int64(len(data)), total)
+
+		last = 0
+		_, err = DecryptAnyContext(context.Background(), out, dec, &Keys{Key: key}, Opt{Progress: progress})
+		require.NoError(t, err)
+		require.Equal(t, total, last)
+		requireFile(t, dec, data)
+
+		// 取消后不产生输出文件
+		ctx, cancel := context.WithCancel(context.Background())
+		opt.Progress = func(done, total int64) { cancel() }
+		err = EncryptFileContext(ctx, in, filepath.Join(dir, "canceled.txt"), key, opt)
+		require.ErrorIs(t, err, context.Canceled)
+		_, err = DecryptAnyContext(ctx, out, filepath.Join(dir, "canceled.txt"), &Keys{Key: key})
+		require.ErrorIs(t, err, context.Canceled)
+		require.NoFileExists(t, filepath.Join(dir, "canceled.txt"))
+	}
 }
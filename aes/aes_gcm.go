@@ -9,6 +9,15 @@ import (
 )
 
 func EncryptGCM(plaintext, key []byte) ([]byte, error) {
+	return EncryptGCMWithAD(plaintext, key, nil)
+}
+
+func DecryptGCM(encrypted, key []byte) ([]byte, error) {
+	return DecryptGCMWithAD(encrypted, key, nil)
+}
+
+// EncryptGCMWithAD 输出 nonce | 密文, additionalData 参与认证但不加密, 解密时必须提供相同的值
+func EncryptGCMWithAD(plaintext, key, additionalData []byte) ([]byte, error) {
 	c, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -24,10 +33,11 @@ func EncryptGCM(plaintext, key []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
 }
 
-func DecryptGCM(encrypted, key []byte) ([]byte, error) {
+// DecryptGCMWithAD 解密 EncryptGCMWithAD 的输出
+func DecryptGCMWithAD(encrypted, key, additionalData []byte) ([]byte, error) {
 	c, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -44,5 +54,5 @@ func DecryptGCM(encrypted, key []byte) ([]byte, error) {
 	}
 
 	nonce, encrypted := encrypted[:nonceSize], encrypted[nonceSize:]
-	return gcm.Open(nil, nonce, encrypted, nil)
+	return gcm.Open(nil, nonce, encrypted, additionalData)
 }
@@ -0,0 +1,250 @@
+package aes
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrInvalidKDFParams 口令派生参数不合法
+var ErrInvalidKDFParams = errors.New("invalid kdf params")
+
+const (
+	kdfKeyLen  = 32
+	kdfSaltLen = 16
+
+	// 限制参数上限, 防止恶意文件头耗尽资源
+	maxArgon2Memory = 1 << 20 // KiB
+	maxArgon2Time   = 64
+	maxScryptMemory = 1 << 30 // 128 * N * r * p 字节, 同时限制计算量
+	maxScryptP      = 16
+	maxPBKDF2Iter   = 10000000
+)
+
+// KDFParams 口令派生参数, 与密文一起保存
+type KDFParams struct {
+	KDF  KDFID // KDFArgon2id, KDFScrypt 或 KDFPBKDF2
+	Salt []byte
+
+	// Argon2id
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+
+	// scrypt, N = 1 << LogN
+	LogN uint8
+	R    uint32
+	P    uint32
+
+	// PBKDF2-HMAC-SHA256
+	Iter uint32
+}
+
+var (
+	// DefaultArgon2id 默认 Argon2id 参数(RFC 9106)
+	DefaultArgon2id = KDFParams{KDF: KDFArgon2id, Time: 3, Memory: 64 * 1024, Threads: 4}
+	// DefaultScrypt 默认 scrypt 参数
+	DefaultScrypt = KDFParams{KDF: KDFScrypt, LogN: 15, R: 8, P: 1}
+	// DefaultPBKDF2 默认 PBKDF2 参数
+	DefaultPBKDF2 = KDFParams{KDF: KDFPBKDF2, Iter: 600000}
+)
+
+// NewKDFParams 使用 kdf 的默认参数并生成随机 salt
+func NewKDFParams(kdf KDFID) (*KDFParams, error) {
+	var p KDFParams
+	switch kdf {
+	case KDFArgon2id:
+		p = DefaultArgon2id
+	case KDFScrypt:
+		p = DefaultScrypt
+	case KDFPBKDF2:
+		p = DefaultPBKDF2
+	default:
+		return nil, ErrInvalidKDFParams
+	}
+	return p.withSalt()
+}
+
+// 复制参数并生成新的 salt
+func (p *KDFParams) withSalt() (*KDFParams, error) {
+	salt, err := GenAesKey(kdfSaltLen)
+	if err != nil {
+		return nil, err
+	}
+	tmp := *p
+	tmp.Salt = salt
+	return &tmp, nil
+}
+
+// DeriveKey 从口令派生 32 字节 key
+func (p *KDFParams) DeriveKey(password []byte) ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	switch p.KDF {
+	case KDFArgon2id:
+		return argon2.IDKey(password, p.Salt, p.Time, p.Memory, p.Threads, kdfKeyLen), nil
+	case KDFScrypt:
+		return scrypt.Key(password, p.Salt, 1<<p.LogN, int(p.R), int(p.P), kdfKeyLen)
+	default:
+		return pbkdf2.Key(password, p.Salt, int(p.Iter), kdfKeyLen, sha256.New), nil
+	}
+}
+
+// NeedsRehash 参数弱于 target 时返回 true, 此时应使用 target 重新加密
+func (p *KDFParams) NeedsRehash(target *KDFParams) bool {
+	if p.KDF != target.KDF || len(p.Salt) < kdfSaltLen {
+		return true
+	}
+	switch p.KDF {
+	case KDFArgon2id:
+		return p.Time < target.Time || p.Memory < target.Memory
+	case KDFScrypt:
+		return p.LogN < target.LogN || p.R < target.R || p.P < target.P
+	default:
+		return p.Iter < target.Iter
+	}
+}
+
+func (p *KDFParams) validate() error {
+	if len(p.Salt) == 0 || len(p.Salt) > 0xff {
+		return ErrInvalidKDFParams
+	}
+	switch p.KDF {
+	case KDFArgon2id:
+		if p.Time == 0 || p.Time > maxArgon2Time || p.Memory == 0 || p.Memory > maxArgon2Memory || p.Threads == 0 {
+			return ErrInvalidKDFParams
+		}
+	case KDFScrypt:
+		if p.LogN == 0 || p.LogN > 30 || p.R == 0 || p.P == 0 || p.P > maxScryptP ||
+			uint64(p.R)*uint64(p.P) > maxScryptMemory/(128<<p.LogN) {
+			return ErrInvalidKDFParams
+		}
+	case KDFPBKDF2:
+		if p.Iter == 0 || p.Iter > maxPBKDF2Iter {
+			return ErrInvalidKDFParams
+		}
+	default:
+		return ErrInvalidKDFParams
+	}
+	return nil
+}
+
+// MarshalBinary kdf(1) | len(salt)(1) | salt | 参数(BE)
+func (p *KDFParams) MarshalBinary() ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	buf := []byte{byte(p.KDF), byte(len(p.Salt))}
+	buf = append(buf, p.Salt...)
+	switch p.KDF {
+	case KDFArgon2id:
+		buf = binary.BigEndian.AppendUint32(buf, p.Time)
+		buf = binary.BigEndian.AppendUint32(buf, p.Memory)
+		buf = append(buf, p.Threads)
+	case KDFScrypt:
+		buf = append(buf, p.LogN)
+		buf = binary.BigEndian.AppendUint32(buf, p.R)
+		buf = binary.BigEndian.AppendUint32(buf, p.P)
+	default:
+		buf = binary.BigEndian.AppendUint32(buf, p.Iter)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary 解析 MarshalBinary 的输出
+func (p *KDFParams) UnmarshalBinary(data []byte) error {
+	_, err := p.unmarshal(data)
+	return err
+}
+
+// 返回参数的长度
+func (p *KDFParams) unmarshal(data []byte) (int, error) {
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return 0, ErrInvalidKDFParams
+	}
+	p.KDF = KDFID(data[0])
+	p.Salt = append([]byte{}, data[2:2+int(data[1])]...)
+	n := 2 + len(p.Salt)
+	rest := data[n:]
+	switch p.KDF {
+	case KDFArgon2id:
+		if len(rest) < 9 {
+			return 0, ErrInvalidKDFParams
+		}
+		p.Time = binary.BigEndian.Uint32(rest)
+		p.Memory = binary.BigEndian.Uint32(rest[4:])
+		p.Threads = rest[8]
+		n += 9
+	case KDFScrypt:
+		if len(rest) < 9 {
+			return 0, ErrInvalidKDFParams
+		}
+		p.LogN = rest[0]
+		p.R = binary.BigEndian.Uint32(rest[1:])
+		p.P = binary.BigEndian.Uint32(rest[5:])
+		n += 9
+	case KDFPBKDF2:
+		if len(rest) < 4 {
+			return 0, ErrInvalidKDFParams
+		}
+		p.Iter = binary.BigEndian.Uint32(rest)
+		n += 4
+	default:
+		return 0, ErrInvalidKDFParams
+	}
+	return n, p.validate()
+}
+
+// EncryptWithPassword 使用口令加密, 输出为 KDF 参数 | GCM 密文.
+// params 为空时使用 DefaultArgon2id, salt 总是随机生成
+func EncryptWithPassword(plaintext, password []byte, params ...*KDFParams) ([]byte, error) {
+	p := &DefaultArgon2id
+	if len(params) > 0 && params[0] != nil {
+		p = params[0]
+	}
+	p, err := p.withSalt()
+	if err != nil {
+		return nil, err
+	}
+	key, err := p.DeriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	head, err := p.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := EncryptGCM(plaintext, key)
+	if err != nil {
+		return nil, err
+	}
+	return append(head, encrypted...), nil
+}
+
+// DecryptWithPassword 解密 EncryptWithPassword 的输出
+func DecryptWithPassword(encrypted, password []byte) ([]byte, error) {
+	var p KDFParams
+	n, err := p.unmarshal(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	key, err := p.DeriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptGCM(encrypted[n:], key)
+}
+
+// ReadKDFParams 读取 EncryptWithPassword 输出中的 KDF 参数, 可用于 NeedsRehash
+func ReadKDFParams(encrypted []byte) (*KDFParams, error) {
+	var p KDFParams
+	if _, err := p.unmarshal(encrypted); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,94 @@
+package aes
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptWithPassword(t *testing.T) {
+	password := []byte("123")
+	source := []byte("hello world")
+
+	for _, kdf := range []KDFID{KDFArgon2id, KDFScrypt, KDFPBKDF2} {
+		params, err := NewKDFParams(kdf)
+		require.NoError(t, err)
+		encrypted, err := EncryptWithPassword(source, password, params)
+		require.NoError(t, err)
+
+		decrypted, err := DecryptWithPassword(encrypted, password)
+		require.NoError(t, err)
+		require.Equal(t, source, decrypted)
+
+		_, err = DecryptWithPassword(encrypted, []byte("1234"))
+		require.Error(t, err)
+
+		p, err := ReadKDFParams(encrypted)
+		require.NoError(t, err)
+		require.Equal(t, kdf, p.KDF)
+		require.NotEqual(t, params.Salt, p.Salt)
+		require.False(t, p.NeedsRehash(params))
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	weak := KDFParams{KDF: KDFArgon2id, Salt: make([]byte, 16), Time: 1, Memory: 1024, Threads: 1}
+	encrypted, err := EncryptWithPassword([]byte("hello world"), []byte("123"), &weak)
+	require.NoError(t, err)
+
+	p, err := ReadKDFParams(encrypted)
+	require.NoError(t, err)
+	require.True(t, p.NeedsRehash(&DefaultArgon2id))
+	require.True(t, p.NeedsRehash(&DefaultScrypt))
+	require.False(t, p.NeedsRehash(&weak))
+}
+
+func TestKDFParamsLimit(t *testing.T) {
+	salt := make([]byte, kdfSaltLen)
+	for _, p := range []KDFParams{
+		{KDF: KDFScrypt, Salt: salt, LogN: 1, R: 1, P: 1 << 29},
+		{KDF: KDFScrypt, Salt: salt, LogN: 1, R: 1, P: maxScryptP + 1},
+		{KDF: KDFScrypt, Salt: salt, LogN: 20, R: 8, P: 2},
+		{KDF: KDFScrypt, Salt: salt, LogN: 1, R: 1 << 31, P: maxScryptP},
+		{KDF: KDFArgon2id, Salt: salt, Time: 1, Memory: maxArgon2Memory + 1, Threads: 1},
+		{KDF: KDFPBKDF2, Salt: salt, Iter: maxPBKDF2Iter + 1},
+	} {
+		_, err := p.DeriveKey([]byte("123"))
+		require.ErrorIs(t, err, ErrInvalidKDFParams)
+	}
+
+	// 恶意文件头在派生 key 之前被拒绝
+	data := append([]byte{byte(KDFScrypt), byte(len(salt))}, salt...)
+	data = append(data, 1, 0, 0, 0, 1, 0x20, 0, 0, 0)
+	var p KDFParams
+	require.ErrorIs(t, p.UnmarshalBinary(data), ErrInvalidKDFParams)
+
+	ok := KDFParams{KDF: KDFScrypt, Salt: salt, LogN: 10, R: 8, P: maxScryptP}
+	_, err := ok.DeriveKey([]byte("123"))
+	require.NoError(t, err)
+}
+
+func TestEncryptFileWithPassword(t *testing.T) {
+	dir := t.TempDir()
+	in, out, dec := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"), filepath.Join(dir, "dec.txt")
+	data := bytes.Repeat([]byte("hello world"), 10000)
+	require.NoError(t, os.WriteFile(in, data, 0600))
+
+	password := []byte("123")
+	params := &KDFParams{KDF: KDFScrypt, LogN: 10, R: 8, P: 1}
+	require.NoError(t, EncryptFileWithPassword(in, out, password, Opt{KDFParams: params}))
+	require.NoError(t, DecryptFileWithPassword(out, dec, password))
+	requireFile(t, dec, data)
+	require.ErrorIs(t, DecryptFileWithPassword(out, dec, []byte("1234")), ErrAuth)
+
+	f, err := os.Open(out)
+	require.NoError(t, err)
+	defer f.Close()
+	h, err := ReadHeader(f)
+	require.NoError(t, err)
+	require.Equal(t, KDFScrypt, h.KDF)
+	require.True(t, h.KDFParams.NeedsRehash(&DefaultScrypt))
+}
@@ -15,7 +15,9 @@ func GenAesKey(size int) ([]byte, error) {
 	return key, nil
 }
 
-// Gen256KeyFromPassword 从密码生成 key
+// Gen256KeyFromPassword 从密码生成 key, 仅截断或补零, 没有 salt 和工作因子.
+//
+// Deprecated: 使用 KDFParams.DeriveKey
 func Gen256KeyFromPassword(password []byte) []byte {
 	key := make([]byte, 32)
 	if len(password) > 32 {
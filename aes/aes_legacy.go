@@ -0,0 +1,132 @@
+package aes
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"io"
+)
+
+// legacyBufLen 旧格式需要预读路径字段, 缓冲区需能容纳最长的字段
+const legacyBufLen = 0xffff + 2
+
+// 旧格式(没有文件头)的解密
+//
+//	EncryptFile:               密文 | iv, fixedIV 时没有 iv
+//	EncryptFileWithRSA:        len(2, LE) | rsa(gcm(key|iv)) | 密文
+//	EncryptFileAndPathWithRSA: len(2, LE) | rsa(gcm(key|iv)) | len(2, LE) | gcm(path) | 密文
+//
+// 非 fixedIV 的 EncryptFile 格式 iv 在末尾, 要求 src 实现 io.ReadSeeker
+func newLegacyReader(src io.Reader, br *bufio.Reader, keys *Keys) (*Reader, error) {
+	if keys.PrivateKey != nil {
+		return newLegacyRSAReader(br, keys)
+	}
+	if keys.Key == nil {
+		return nil, ErrMissingKey
+	}
+
+	block, err := aes.NewCipher(keys.Key)
+	if err != nil {
+		return nil, err
+	}
+	if keys.FixedIV {
+		iv := keys.Key[:block.BlockSize()]
+		return &Reader{r: cipher.StreamReader{S: cipher.NewCTR(block, iv), R: br}}, nil
+	}
+
+	rs, ok := src.(io.ReadSeeker)
+	if !ok {
+		return nil, errors.New("legacy format requires io.ReadSeeker")
+	}
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	fileLen := end - int64(block.BlockSize())
+	if fileLen < 0 {
+		return nil, errors.New("encrypted too short")
+	}
+	if _, err = rs.Seek(fileLen, io.SeekStart); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, block.BlockSize())
+	if _, err = io.ReadFull(rs, iv); err != nil {
+		return nil, err
+	}
+	if _, err = rs.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return &Reader{r: cipher.StreamReader{S: cipher.NewCTR(block, iv), R: io.LimitReader(rs, fileLen)}}, nil
+}
+
+func newLegacyRSAReader(br *bufio.Reader, keys *Keys) (*Reader, error) {
+	block, iv, path, _, err := readLegacyRSAHeader(br, keys)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: cipher.StreamReader{S: cipher.NewCTR(block, iv), R: br}, path: path}, nil
+}
+
+// 读取 RSA 旧格式密文前的字段, 返回密文的偏移
+func readLegacyRSAHeader(br *bufio.Reader, keys *Keys) (cipher.Block, []byte, string, int64, error) {
+	if keys.KeyKey == nil {
+		return nil, nil, "", 0, ErrMissingKey
+	}
+	encTmp, err := readLegacyField(br)
+	if err != nil {
+		return nil, nil, "", 0, err
+	}
+	offset := int64(2 + len(encTmp))
+	// rsa 解密
+	keyIV, err := RsaDecrypt(encTmp, keys.PrivateKey)
+	if err != nil {
+		return nil, nil, "", 0, err
+	}
+	// aes 解密
+	keyIV, err = DecryptGCM(keyIV, keys.KeyKey)
+	if err != nil {
+		return nil, nil, "", 0, err
+	}
+	if len(keyIV) != 32+aes.BlockSize {
+		return nil, nil, "", 0, errors.New("invalid key iv")
+	}
+	key, iv := keyIV[:32], keyIV[32:]
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, "", 0, err
+	}
+
+	// 尝试读取路径, 失败说明是 EncryptFileWithRSA 格式
+	var path string
+	if head, err2 := br.Peek(2); err2 == nil {
+		n := int(head[0]) | int(head[1])<<8
+		if field, err3 := br.Peek(2 + n); err3 == nil {
+			if pathDec, err4 := DecryptGCM(field[2:], keys.KeyKey); err4 == nil {
+				path = string(pathDec)
+				if _, err = br.Discard(2 + n); err != nil {
+					return nil, nil, "", 0, err
+				}
+				offset += int64(2 + n)
+			}
+		}
+	}
+	return block, iv, path, offset, nil
+}
+
+func readLegacyField(r io.Reader) ([]byte, error) {
+	tmp := make([]byte, 2)
+	if _, err := io.ReadFull(r, tmp); err != nil {
+		return nil, err
+	}
+	n := int(tmp[0]) | int(tmp[1])<<8
+	if n > bufLen {
+		return nil, errors.New("len(rsa) out of index")
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,160 @@
+package aes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+/*
+AES-SIV(RFC 5297): 确定性认证加密, 相同的 key, 明文和 additionalData 总是得到相同的密文, 可用于去重和唯一索引.
+additionalData 的最后一项使用随机 nonce 时即为普通 AEAD, nonce 重复也只会暴露明文是否相同.
+	key = K1(CMAC) | K2(CTR), 32, 48 或 64 字节分别对应 AES-128/192/256
+	siv = S2V(K1, additionalData..., 明文)
+	输出 siv(16) | AES-CTR(K2, siv 清除第 63 和 31 位, 明文)
+*/
+
+var (
+	// ErrInvalidSIVKey key 长度不是 32, 48 或 64 字节
+	ErrInvalidSIVKey = errors.New("invalid siv key size")
+	// ErrTooManyAD additionalData 最多 126 项
+	ErrTooManyAD = errors.New("too many additional data")
+)
+
+const (
+	sivSize  = aes.BlockSize
+	maxSIVAD = 126
+)
+
+// EncryptSIV AES-SIV 加密, 输出 siv | 密文
+func EncryptSIV(plaintext, key []byte, additionalData ...[]byte) ([]byte, error) {
+	mac, ctr, err := newSIVCiphers(key, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	v := s2v(mac, additionalData, plaintext)
+	out := make([]byte, sivSize+len(plaintext))
+	copy(out, v)
+	sivCTR(ctr, v).XORKeyStream(out[sivSize:], plaintext)
+	return out, nil
+}
+
+// DecryptSIV 解密 EncryptSIV 的输出, additionalData 必须与加密时相同
+func DecryptSIV(encrypted, key []byte, additionalData ...[]byte) ([]byte, error) {
+	if len(encrypted) < sivSize {
+		return nil, errors.New("encrypted too short")
+	}
+	mac, ctr, err := newSIVCiphers(key, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	v := encrypted[:sivSize]
+	plaintext := make([]byte, len(encrypted)-sivSize)
+	sivCTR(ctr, v).XORKeyStream(plaintext, encrypted[sivSize:])
+	if subtle.ConstantTimeCompare(v, s2v(mac, additionalData, plaintext)) != 1 {
+		return nil, ErrAuth
+	}
+	return plaintext, nil
+}
+
+func newSIVCiphers(key []byte, additionalData [][]byte) (cipher.Block, cipher.Block, error) {
+	if len(key) != 32 && len(key) != 48 && len(key) != 64 {
+		return nil, nil, ErrInvalidSIVKey
+	}
+	if len(additionalData) > maxSIVAD {
+		return nil, nil, ErrTooManyAD
+	}
+	mac, err := aes.NewCipher(key[:len(key)/2])
+	if err != nil {
+		return nil, nil, err
+	}
+	ctr, err := aes.NewCipher(key[len(key)/2:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return mac, ctr, nil
+}
+
+func sivCTR(block cipher.Block, v []byte) cipher.Stream {
+	q := make([]byte, sivSize)
+	copy(q, v)
+	q[8] &= 0x7f
+	q[12] &= 0x7f
+	return cipher.NewCTR(block, q)
+}
+
+// S2V 把多个字符串压缩为一个 16 字节的值
+func s2v(block cipher.Block, additionalData [][]byte, plaintext []byte) []byte {
+	c := newCMAC(block)
+	d := c.sum(make([]byte, sivSize))
+	for _, ad := range additionalData {
+		dbl(d)
+		xorBytes(d, d, c.sum(ad))
+	}
+	var t []byte
+	if len(plaintext) >= sivSize {
+		t = append(t, plaintext...)
+		n := len(t) - sivSize
+		xorBytes(t[n:], t[n:], d)
+	} else {
+		dbl(d)
+		t = make([]byte, sivSize)
+		copy(t, plaintext)
+		t[len(plaintext)] = 0x80
+		xorBytes(t, t, d)
+	}
+	return c.sum(t)
+}
+
+// cmac AES-CMAC(RFC 4493)
+type cmac struct {
+	block  cipher.Block
+	k1, k2 []byte
+}
+
+func newCMAC(block cipher.Block) *cmac {
+	k1 := make([]byte, aes.BlockSize)
+	block.Encrypt(k1, k1)
+	dbl(k1)
+	k2 := append([]byte(nil), k1...)
+	dbl(k2)
+	return &cmac{block: block, k1: k1, k2: k2}
+}
+
+func (c *cmac) sum(msg []byte) []byte {
+	x := make([]byte, aes.BlockSize)
+	for len(msg) > aes.BlockSize {
+		xorBytes(x, x, msg[:aes.BlockSize])
+		c.block.Encrypt(x, x)
+		msg = msg[aes.BlockSize:]
+	}
+	// 最后一块, 完整时异或 k1, 否则填充 10* 后异或 k2
+	last := make([]byte, aes.BlockSize)
+	copy(last, msg)
+	if len(msg) == aes.BlockSize {
+		xorBytes(last, last, c.k1)
+	} else {
+		last[len(msg)] = 0x80
+		xorBytes(last, last, c.k2)
+	}
+	xorBytes(x, x, last)
+	c.block.Encrypt(x, x)
+	return x
+}
+
+// GF(2^128) 上乘以 x
+func dbl(b []byte) {
+	carry := b[0] >> 7
+	for i := 0; i < len(b)-1; i++ {
+		b[i] = b[i]<<1 | b[i+1]>>7
+	}
+	b[len(b)-1] = b[len(b)-1]<<1 ^ 0x87*carry
+}
+
+// dst = a ^ b, 长度以 b 为准
+func xorBytes(dst, a, b []byte) {
+	for i := range b {
+		dst[i] = a[i] ^ b[i]
+	}
+}
@@ -0,0 +1,75 @@
+package aes
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	require.NoError(t, err)
+	return b
+}
+
+// RFC 5297 附录 A
+func TestEncryptSIV(t *testing.T) {
+	cases := []struct {
+		key, plaintext, encrypted string
+		ad                        []string
+	}{
+		{
+			key:       "fffefdfc fbfaf9f8 f7f6f5f4 f3f2f1f0 f0f1f2f3 f4f5f6f7 f8f9fafb fcfdfeff",
+			ad:        []string{"10111213 14151617 18191a1b 1c1d1e1f 20212223 24252627"},
+			plaintext: "11223344 55667788 99aabbcc ddee",
+			encrypted: "85632d07 c6e8f37f 950acd32 0a2ecc93 40c02b96 90c4dc04 daef7f6a fe5c",
+		},
+		{
+			key: "7f7e7d7c 7b7a7978 77767574 73727170 40414243 44454647 48494a4b 4c4d4e4f",
+			ad: []string{
+				"00112233 44556677 8899aabb ccddeeff deaddada deaddada ffeeddcc bbaa9988 77665544 33221100",
+				"10203040 50607080 90a0",
+				"09f91102 9d74e35b d84156c5 635688c0",
+			},
+			plaintext: "74686973 20697320 736f6d65 20706c61 696e7465 78742074 6f20656e 63727970 74207573 696e6720 5349562d 414553",
+			encrypted: "7bdb6e3b 432667eb 06f4d14b ff2fbd0f cb900f2f ddbe4043 26601965 c889bf17 dba77ceb 094fa663 b7a3f748 ba8af829 ea64ad54 4a272e9c 485b62a3 fd5c0d",
+		},
+	}
+	for _, c := range cases {
+		var ad [][]byte
+		for _, s := range c.ad {
+			ad = append(ad, unhex(t, s))
+		}
+		key := unhex(t, c.key)
+		encrypted, err := EncryptSIV(unhex(t, c.plaintext), key, ad...)
+		require.NoError(t, err)
+		require.Equal(t, unhex(t, c.encrypted), encrypted)
+
+		plaintext, err := DecryptSIV(encrypted, key, ad...)
+		require.NoError(t, err)
+		require.Equal(t, unhex(t, c.plaintext), plaintext)
+
+		_, err = DecryptSIV(encrypted, key)
+		require.ErrorIs(t, err, ErrAuth)
+		encrypted[len(encrypted)-1] ^= 1
+		_, err = DecryptSIV(encrypted, key, ad...)
+		require.ErrorIs(t, err, ErrAuth)
+	}
+
+	// 确定性
+	key, err := GenAesKey(64)
+	require.NoError(t, err)
+	a, err := EncryptSIV([]byte("hello"), key, []byte("user"))
+	require.NoError(t, err)
+	b, err := EncryptSIV([]byte("hello"), key, []byte("user"))
+	require.NoError(t, err)
+	require.Equal(t, a, b)
+	plaintext, err := DecryptSIV(a, key, []byte("user"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), plaintext)
+
+	_, err = EncryptSIV([]byte("hello"), key[:16])
+	require.ErrorIs(t, err, ErrInvalidSIVKey)
+}
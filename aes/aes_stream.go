@@ -0,0 +1,370 @@
+package aes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/happyxhw/pkg/fsutil"
+)
+
+// ErrMissingKey 缺少解密所需的密钥
+var ErrMissingKey = errors.New("missing key")
+
+// Opt 加密选项
+type Opt struct {
+	Path      string     // 加密后写入文件头的原始路径, 为空时不写入
+	KDFParams *KDFParams // 口令模式的派生参数, 为空时使用 DefaultArgon2id, salt 总是随机生成
+	OAEP      bool       // RSA 模式使用 OAEP 而不是 PKCS#1 v1.5
+	SplitSize int64      // 大于 0 时文件按 fsutil.Split 的分片并发加密, 仅用于文件
+	Workers   int        // 分片加密和解密的并发数, 默认 runtime.NumCPU()
+	Compress  Codec      // 加密前压缩, 文件函数在输入已经是压缩数据时不压缩
+
+	// 以下仅用于写入文件, 输出总是先写入临时文件, 完成后 fsync 并改名
+	Perm        os.FileMode         // 输出文件的权限, 默认 0600
+	NoOverwrite bool                // 输出文件已存在时返回 os.ErrExist
+	Progress    fsutil.ProgressFunc // 进度回调, total 为输入文件大小, 分片文件并发解密时为明文大小
+}
+
+func getOpt(opts ...Opt) Opt {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return Opt{}
+}
+
+// WriterFunc 返回包装 w 的加密 writer, 如
+//
+//	func(w io.Writer) (io.WriteCloser, error) { return NewEncryptWriter(w, key) }
+type WriterFunc func(w io.Writer) (io.WriteCloser, error)
+
+// Keys 解密时可用的密钥, 根据文件头选择需要的密钥
+type Keys struct {
+	Key        []byte          // EncryptFile 使用的 key
+	Keyring    *Keyring        // 文件头带 KeyID 时使用
+	KeyKey     []byte          // RSA 模式下加密随机 key 的 key
+	PrivateKey *rsa.PrivateKey // RSA 模式的私钥
+	Password   []byte          // 口令模式的口令
+	X25519     X25519PrivateKey
+	Identities []Identity  // 多接收方模式的私钥, PrivateKey 和 X25519 也会被使用
+	Provider   KeyProvider // 文件头为 KDFProvider 时解开内容密钥
+	FixedIV    bool        // 仅用于没有文件头的旧格式 EncryptFile 文件
+}
+
+// NewEncryptWriter 返回加密 writer, 写完数据后必须调用 Close, Close 不会关闭 w
+func NewEncryptWriter(w io.Writer, key []byte, opts ...Opt) (io.WriteCloser, error) {
+	return newEncryptWriter(w, &Header{KDF: KDFRaw}, key, getOpt(opts...))
+}
+
+// NewEncryptWriterWithRSA 随机生成 key 加密数据, key 经 keyKey 和 pk 加密后写入文件头
+func NewEncryptWriterWithRSA(w io.Writer, keyKey []byte, pk *rsa.PublicKey, opts ...Opt) (io.WriteCloser, error) {
+	opt := getOpt(opts...)
+	h, key, err := newRSAHeader(keyKey, pk, opt.OAEP)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptWriter(w, h, key, opt)
+}
+
+// NewEncryptWriterWithPassword 从口令派生 key 加密数据, 派生参数写入文件头
+func NewEncryptWriterWithPassword(w io.Writer, password []byte, opts ...Opt) (io.WriteCloser, error) {
+	opt := getOpt(opts...)
+	h, key, err := newPasswordHeader(password, opt.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptWriter(w, h, key, opt)
+}
+
+// 使用分块 AES-GCM 加密, h 中只需设置 KDF, Flags 及密钥相关字段
+func newEncryptWriter(w io.Writer, h *Header, key []byte, opt Opt) (io.WriteCloser, error) {
+	if err := initHeader(h, key, opt); err != nil {
+		return nil, err
+	}
+	cw, err := newChunkedWriter(w, h, key)
+	if err != nil {
+		return nil, err
+	}
+	if h.Codec == CodecNone {
+		return cw, nil
+	}
+	zw, err := newCompressWriter(cw, h.Codec)
+	if err != nil {
+		return nil, err
+	}
+	return &compressWriter{WriteCloser: zw, w: cw}, nil
+}
+
+// 设置文件头中各分片相同的字段
+func initHeader(h *Header, key []byte, opt Opt) error {
+	var err error
+	h.Version, h.Cipher, h.ChunkSize, h.Codec = Version1, CipherAES256GCMChunk, DefaultChunkSize, opt.Compress
+	if opt.Path != "" {
+		h.Flags |= FlagPath
+		if h.Path, err = EncryptGCM([]byte(opt.Path), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 生成随机 salt 并写入文件头, 返回分块加密 writer
+func newChunkedWriter(w io.Writer, h *Header, key []byte) (*chunkWriter, error) {
+	var err error
+	if h.Salt, err = GenAesKey(32); err != nil {
+		return nil, err
+	}
+	aead, err := newChunkAEAD(key, h)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = h.WriteTo(w); err != nil {
+		return nil, err
+	}
+	return newChunkWriter(w, aead, int(h.ChunkSize)), nil
+}
+
+// 从口令派生 key, params 为空时使用 DefaultArgon2id
+func newPasswordHeader(password []byte, params *KDFParams) (*Header, []byte, error) {
+	if params == nil {
+		params = &DefaultArgon2id
+	}
+	params, err := params.withSalt()
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := params.DeriveKey(password)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Header{KDF: params.KDF, KDFParams: params}, key, nil
+}
+
+// 随机生成 key, 经 keyKey 和 rsa 加密后存于文件头
+func newRSAHeader(keyKey []byte, pk *rsa.PublicKey, oaep bool) (*Header, []byte, error) {
+	key, err := GenAesKey(32)
+	if err != nil {
+		return nil, nil, err
+	}
+	// aes 加密 key
+	wrapped, err := EncryptGCM(key, keyKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	// rsa 加密 key
+	kdf := KDFRSA
+	if oaep {
+		kdf = KDFRSAOAEP
+		wrapped, err = RsaEncryptOAEP(wrapped, pk)
+	} else {
+		wrapped, err = RsaEncrypt(wrapped, pk)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Header{KDF: kdf, WrappedKey: wrapped}, key, nil
+}
+
+// Reader 解密 reader
+type Reader struct {
+	r      io.Reader
+	header *Header
+	path   string
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+// Header 文件头, 旧格式时为 nil
+func (r *Reader) Header() *Header {
+	return r.header
+}
+
+// Path 文件头中的原始路径, 没有时为空
+func (r *Reader) Path() string {
+	return r.path
+}
+
+// NewDecryptReader 解密 NewEncryptWriter 的输出
+func NewDecryptReader(r io.Reader, key []byte) (*Reader, error) {
+	return NewDecryptReaderAny(r, &Keys{Key: key})
+}
+
+// NewDecryptReaderWithRSA 解密 NewEncryptWriterWithRSA 的输出
+func NewDecryptReaderWithRSA(r io.Reader, keyKey []byte, pk *rsa.PrivateKey) (*Reader, error) {
+	return NewDecryptReaderAny(r, &Keys{KeyKey: keyKey, PrivateKey: pk})
+}
+
+// NewDecryptReaderWithPassword 解密 NewEncryptWriterWithPassword 的输出
+func NewDecryptReaderWithPassword(r io.Reader, password []byte) (*Reader, error) {
+	return NewDecryptReaderAny(r, &Keys{Password: password})
+}
+
+// NewDecryptReaderAny 根据文件头选择解密方式, 没有文件头时按旧格式解密
+func NewDecryptReaderAny(r io.Reader, keys *Keys) (*Reader, error) {
+	return newDecryptReader(context.Background(), r, keys)
+}
+
+// ctx 用于 KeyProvider 解开内容密钥
+func newDecryptReader(ctx context.Context, r io.Reader, keys *Keys) (*Reader, error) {
+	br := bufio.NewReaderSize(r, legacyBufLen)
+	prefix, err := br.Peek(len(magic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if !bytes.Equal(prefix, magic) {
+		return newLegacyReader(r, br, keys)
+	}
+
+	h, err := ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	key, path, err := keys.open(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	var pr io.Reader
+	if h.Part != nil {
+		pr, err = newPartsReader(br, h, key)
+	} else {
+		pr, err = newPayloadReader(br, h, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{r: pr, header: h, path: path}, nil
+}
+
+// 返回内容密钥和文件头中的原始路径
+func (k *Keys) open(ctx context.Context, h *Header) ([]byte, string, error) {
+	key, err := k.contentKey(ctx, h)
+	if err != nil {
+		return nil, "", err
+	}
+	if h.Flags&FlagPath == 0 {
+		return key, "", nil
+	}
+	path, err := DecryptGCM(h.Path, key)
+	if err != nil {
+		return nil, "", ErrAuth
+	}
+	return key, string(path), nil
+}
+
+// 取得文件头对应的内容密钥
+func (k *Keys) contentKey(ctx context.Context, h *Header) ([]byte, error) {
+	switch h.KDF {
+	case KDFRaw:
+		if h.KeyID != "" {
+			if k.Keyring == nil {
+				return nil, ErrMissingKey
+			}
+			return k.Keyring.Get(h.KeyID)
+		}
+		if k.Key == nil {
+			return nil, ErrMissingKey
+		}
+		return k.Key, nil
+	case KDFRSA, KDFRSAOAEP:
+		if k.PrivateKey == nil || k.KeyKey == nil {
+			return nil, ErrMissingKey
+		}
+		// rsa 解密
+		decrypt := RsaDecrypt
+		if h.KDF == KDFRSAOAEP {
+			decrypt = RsaDecryptOAEP
+		}
+		key, err := decrypt(h.WrappedKey, k.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		// aes 解密
+		key, err = DecryptGCM(key, k.KeyKey)
+		if err != nil {
+			return nil, ErrAuth
+		}
+		return key, nil
+	case KDFArgon2id, KDFScrypt, KDFPBKDF2:
+		if k.Password == nil {
+			return nil, ErrMissingKey
+		}
+		if h.KDFParams == nil || h.KDFParams.KDF != h.KDF {
+			return nil, ErrInvalidHeader
+		}
+		return h.KDFParams.DeriveKey(k.Password)
+	case KDFX25519:
+		if k.X25519 == nil {
+			return nil, ErrMissingKey
+		}
+		return unwrapX25519(h.WrappedKey, h.Ephemeral, k.X25519)
+	case KDFRecipients:
+		identities := k.Identities
+		if k.PrivateKey != nil {
+			identities = append(identities, &RSAIdentity{PrivateKey: k.PrivateKey})
+		}
+		if k.X25519 != nil {
+			identities = append(identities, &X25519Identity{PrivateKey: k.X25519})
+		}
+		return unwrapStanzas(h.Recipients, identities)
+	case KDFProvider:
+		if k.Provider == nil {
+			return nil, ErrMissingKey
+		}
+		return k.Provider.UnwrapKey(ctx, h.KeyID, h.WrappedKey)
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// 根据文件头中的算法解密和解压
+func newPayloadReader(r io.Reader, h *Header, key []byte) (io.Reader, error) {
+	pr, err := newCipherReader(r, h, key)
+	if err != nil || h.Codec == CodecNone {
+		return pr, err
+	}
+	return newDecompressReader(pr, h.Codec)
+}
+
+func newCipherReader(r io.Reader, h *Header, key []byte) (io.Reader, error) {
+	switch h.Cipher {
+	case CipherAES256GCMChunk:
+		aead, err := newChunkAEAD(key, h)
+		if err != nil {
+			return nil, err
+		}
+		return newChunkReader(r, aead, int(h.ChunkSize)), nil
+	case CipherAES256CTR:
+		block, iv, err := newCTRBlock(h, key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.StreamReader{S: cipher.NewCTR(block, iv), R: r}, nil
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// CTR 模式的 block 和 iv
+func newCTRBlock(h *Header, key []byte) (cipher.Block, []byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	iv := key[:block.BlockSize()]
+	if h.Flags&FlagFixedIV == 0 {
+		if len(h.IV) != block.BlockSize() {
+			return nil, nil, ErrInvalidHeader
+		}
+		iv = h.IV
+	}
+	return block, iv, nil
+}
@@ -0,0 +1,73 @@
+package aes
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptWriter(t *testing.T) {
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+	data := bytes.Repeat([]byte("hello world"), 20000)
+
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, key)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	r, err := NewDecryptReader(&buf, key)
+	require.NoError(t, err)
+	dec, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, data, dec)
+	require.Equal(t, CipherAES256GCMChunk, r.Header().Cipher)
+}
+
+func TestEncryptWriterWithRSA(t *testing.T) {
+	keyKey, err := GenAesKey(32)
+	require.NoError(t, err)
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	data := []byte("hello world")
+
+	var buf bytes.Buffer
+	w, err := NewEncryptWriterWithRSA(&buf, keyKey, &pk.PublicKey, Opt{Path: "a/b.txt"})
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	r, err := NewDecryptReaderWithRSA(&buf, keyKey, pk)
+	require.NoError(t, err)
+	dec, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, data, dec)
+	require.Equal(t, "a/b.txt", r.Path())
+
+	// 旧格式, 不要求 io.Seeker
+	enc := legacyEncryptRSA(t, data, "a/b.txt", keyKey, &pk.PublicKey, true)
+	r, err = NewDecryptReaderWithRSA(io.MultiReader(bytes.NewReader(enc)), keyKey, pk)
+	require.NoError(t, err)
+	dec, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, data, dec)
+	require.Equal(t, "a/b.txt", r.Path())
+	require.Nil(t, r.Header())
+
+	// 旧格式 iv 在末尾, 需要 io.Seeker
+	enc = legacyEncrypt(t, data, keyKey, false)
+	_, err = NewDecryptReader(io.MultiReader(bytes.NewReader(enc)), keyKey)
+	require.Error(t, err)
+	r, err = NewDecryptReader(bytes.NewReader(enc), keyKey)
+	require.NoError(t, err)
+	dec, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, data, dec)
+}
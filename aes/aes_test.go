@@ -43,3 +43,19 @@ func TestEncryptGCM(t *testing.T) {
 	require.NoError(t, err)
 	fmt.Println(string(decrypted))
 }
+
+func TestEncryptGCMWithAD(t *testing.T) {
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+
+	encrypted, err := EncryptGCMWithAD([]byte("hello world"), key, []byte("user:1"))
+	require.NoError(t, err)
+	decrypted, err := DecryptGCMWithAD(encrypted, key, []byte("user:1"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), decrypted)
+
+	_, err = DecryptGCMWithAD(encrypted, key, []byte("user:2"))
+	require.Error(t, err)
+	_, err = DecryptGCM(encrypted, key)
+	require.Error(t, err)
+}
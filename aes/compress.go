@@ -0,0 +1,151 @@
+package aes
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+/*
+压缩: 明文先压缩再加密, 文件头的 Codec 记录压缩算法并参与认证, 解密时自动解压.
+压缩后无法按偏移定位明文, RangeReader 返回 ErrCompressed, 也不能与 Opt.SplitSize 同时使用.
+文件函数会检查输入的前 64KiB, 已经是压缩格式或压缩率很低时不压缩.
+注意: 密文长度会暴露明文的可压缩程度, 攻击者可控的数据与秘密混在一起时不要压缩
+*/
+
+// Codec 加密前使用的压缩算法
+type Codec byte
+
+const (
+	// CodecNone 不压缩
+	CodecNone Codec = 0
+	// CodecGzip gzip
+	CodecGzip Codec = 1
+	// CodecZstd zstd
+	CodecZstd Codec = 2
+)
+
+var (
+	// ErrCompressed 压缩后的数据不支持随机读取
+	ErrCompressed = errors.New("random access not supported for compressed data")
+	// ErrCompressParts 压缩不能与分片加密同时使用
+	ErrCompressParts = errors.New("compression cannot be used with split size")
+)
+
+const compressSampleLen = 64 * 1024
+
+// 常见压缩格式和已加密数据的 magic
+var compressedMagics = []struct {
+	offset int
+	magic  []byte
+}{
+	{0, []byte{0x1f, 0x8b}},                       // gzip
+	{0, []byte{0x28, 0xb5, 0x2f, 0xfd}},           // zstd
+	{0, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},   // xz
+	{0, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}}, // 7z
+	{0, []byte("BZh")},                            // bzip2
+	{0, []byte{0x04, 0x22, 0x4d, 0x18}},           // lz4
+	{0, []byte("PK\x03\x04")},                     // zip, docx, jar
+	{0, []byte("Rar!\x1a\x07")},                   // rar
+	{0, []byte{0x89, 'P', 'N', 'G'}},              // png
+	{0, []byte{0xff, 0xd8, 0xff}},                 // jpeg
+	{0, []byte("GIF8")},                           // gif
+	{8, []byte("WEBP")},                           // webp
+	{4, []byte("ftyp")},                           // mp4, mov, heic
+	{0, []byte{0x1a, 0x45, 0xdf, 0xa3}},           // mkv, webm
+	{0, []byte("OggS")},                           // ogg
+	{0, []byte("ID3")},                            // mp3
+	{0, magic},                                    // 本包加密的文件
+}
+
+// 判断样本是否已经是压缩数据: 匹配常见格式的 magic, 或者快速压缩后体积减少不到 10%
+func isCompressed(sample []byte) bool {
+	for _, m := range compressedMagics {
+		if len(sample) >= m.offset+len(m.magic) && bytes.Equal(sample[m.offset:m.offset+len(m.magic)], m.magic) {
+			return true
+		}
+	}
+	if len(sample) == 0 {
+		return false
+	}
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return false
+	}
+	_, _ = fw.Write(sample)
+	_ = fw.Close()
+	return buf.Len()*10 > len(sample)*9
+}
+
+// 读取文件开头判断是否需要压缩
+func shouldCompress(f *os.File) (bool, error) {
+	sample := make([]byte, compressSampleLen)
+	n, err := f.ReadAt(sample, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	return !isCompressed(sample[:n]), nil
+}
+
+func newCompressWriter(w io.Writer, c Codec) (io.WriteCloser, error) {
+	switch c {
+	case CodecGzip:
+		return gzip.NewWriter(w), nil
+	case CodecZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+func newDecompressReader(r io.Reader, c Codec) (io.Reader, error) {
+	switch c {
+	case CodecGzip:
+		return gzip.NewReader(r)
+	case CodecZstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &zstdReader{d: d}, nil
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// 先关闭压缩再关闭加密 writer
+type compressWriter struct {
+	io.WriteCloser
+	w io.WriteCloser
+}
+
+func (c *compressWriter) Close() error {
+	if err := c.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return c.w.Close()
+}
+
+// 读取结束或出错时释放 zstd 解码器
+type zstdReader struct {
+	d   *zstd.Decoder
+	err error
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	n, err := z.d.Read(p)
+	if err != nil {
+		z.err = err
+		z.d.Close()
+	}
+	return n, err
+}
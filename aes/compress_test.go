@@ -0,0 +1,77 @@
+package aes
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompress(t *testing.T) {
+	dir := t.TempDir()
+	in, out, dec := filepath.Join(dir, "in.log"), filepath.Join(dir, "out"), filepath.Join(dir, "dec")
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+	data := []byte(strings.Repeat(`{"level":"info","msg":"request","status":200}`+"\n", 20000))
+	random := make([]byte, 200*1024)
+	_, err = rand.Read(random)
+	require.NoError(t, err)
+
+	for _, codec := range []Codec{CodecGzip, CodecZstd} {
+		// 流式
+		var buf bytes.Buffer
+		w, err := NewEncryptWriter(&buf, key, Opt{Compress: codec})
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.Less(t, buf.Len(), len(data)/10)
+		r, err := NewDecryptReader(bytes.NewReader(buf.Bytes()), key)
+		require.NoError(t, err)
+		require.Equal(t, codec, r.Header().Codec)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, data, got)
+
+		// 压缩算法参与认证
+		enc := buf.Bytes()
+		h, err := ReadHeader(bytes.NewReader(enc))
+		require.NoError(t, err)
+		i := bytes.Index(enc[:h.Size()], []byte{tagCodec, 0, 1, byte(codec)})
+		require.Greater(t, i, 0)
+		enc[i+3] = byte(CodecGzip + CodecZstd - codec)
+		r, err = NewDecryptReader(bytes.NewReader(enc), key)
+		if err == nil {
+			_, err = io.ReadAll(r)
+		}
+		require.ErrorIs(t, err, ErrAuth)
+
+		// 文件
+		require.NoError(t, os.WriteFile(in, data, 0600))
+		require.NoError(t, EncryptFile(in, out, key, false, Opt{Compress: codec}))
+		require.NoError(t, DecryptFile(out, dec, key, false))
+		requireFile(t, dec, data)
+		_, err = OpenRangeReader(out, &Keys{Key: key})
+		require.ErrorIs(t, err, ErrCompressed)
+		require.ErrorIs(t, EncryptFile(in, out, key, false, Opt{Compress: codec, SplitSize: 1024}), ErrCompressParts)
+
+		// 已经压缩的数据不再压缩
+		for _, sample := range [][]byte{random, buf.Bytes(), append([]byte{0x1f, 0x8b}, data...)} {
+			require.NoError(t, os.WriteFile(in, sample, 0600))
+			require.NoError(t, EncryptFile(in, out, key, false, Opt{Compress: codec, SplitSize: 64 * 1024}))
+			f, err := os.Open(out)
+			require.NoError(t, err)
+			h, err := ReadHeader(f)
+			require.NoError(t, f.Close())
+			require.NoError(t, err)
+			require.Equal(t, CodecNone, h.Codec)
+			require.NoError(t, DecryptFile(out, dec, key, false))
+			requireFile(t, dec, sample)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package aes
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/happyxhw/pkg/fsutil"
+)
+
+/*
+目录加密, 输出目录结构:
+	manifest    加密的清单: 内容密钥, 每个文件的相对路径, mode, mtime, size 和对象名
+	<object>    以随机 key 加密的文件内容, 对象名随机生成, 文件头中加密保存相对路径
+清单使用调用方指定的方式加密, 解密清单后即可解密所有文件
+*/
+
+var (
+	// ErrUnsafePath 清单或文件头中的路径是绝对路径或包含 ..
+	ErrUnsafePath = errors.New("unsafe path")
+	// ErrManifestMismatch 加密文件与清单不一致
+	ErrManifestMismatch = errors.New("object does not match manifest")
+)
+
+const manifestName = "manifest"
+
+// DirOpt 目录加密选项
+type DirOpt struct {
+	SkipHidden bool                // 跳过 fsutil.IsHidden 的文件和目录, 仅用于加密
+	Progress   fsutil.ProgressFunc // 进度回调, total 为所有文件的大小之和
+}
+
+func getDirOpt(opts ...DirOpt) DirOpt {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return DirOpt{}
+}
+
+// DirEntry 清单中的一个文件或目录
+type DirEntry struct {
+	Path    string      `json:"path"` // 相对路径, 以 / 分隔
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	Size    int64       `json:"size,omitempty"`
+	Object  string      `json:"object,omitempty"` // 加密后的文件名, 目录为空
+}
+
+// DirManifest 目录清单
+type DirManifest struct {
+	Key     []byte      `json:"key"` // 加密文件内容的 key
+	Entries []*DirEntry `json:"entries"`
+}
+
+// EncryptDir 加密 in 目录下的所有普通文件和目录到 out, 清单使用 newWriter 加密. 符号链接等特殊文件会被忽略
+func EncryptDir(in, out string, newWriter WriterFunc, opts ...DirOpt) error {
+	return EncryptDirContext(context.Background(), in, out, newWriter, opts...)
+}
+
+// EncryptDirContext 同 EncryptDir, 每次读取前检查 ctx, 取消后返回 ctx.Err() 且不写入清单
+func EncryptDirContext(ctx context.Context, in, out string, newWriter WriterFunc, opts ...DirOpt) error {
+	opt := getDirOpt(opts...)
+	key, err := GenAesKey(32)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(out, 0755); err != nil {
+		return err
+	}
+	absOut, err := filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+
+	m := DirManifest{Key: key}
+	var (
+		srcs  []string // 与 m.Entries 对应的源文件
+		total int64
+	)
+	err = filepath.WalkDir(in, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == in {
+			return nil
+		}
+		// 输出目录在输入目录内时跳过
+		if abs, _ := filepath.Abs(p); abs == absOut {
+			return filepath.SkipDir
+		}
+		if opt.SkipHidden && fsutil.IsHidden(p) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(in, p)
+		if err != nil {
+			return err
+		}
+		e := DirEntry{Path: filepath.ToSlash(rel), Mode: fi.Mode(), ModTime: fi.ModTime()}
+		if !d.IsDir() {
+			e.Size = fi.Size()
+			total += e.Size
+			if e.Object, err = newObjectName(); err != nil {
+				return err
+			}
+		}
+		m.Entries = append(m.Entries, &e)
+		srcs = append(srcs, p)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	progress := fsutil.NewProgress(ctx, total, opt.Progress)
+	for i, e := range m.Entries {
+		if e.Object == "" {
+			continue
+		}
+		if err = encryptObject(progress, srcs[i], filepath.Join(out, e.Object), key, e); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(&m)
+	if err != nil {
+		return err
+	}
+	return encryptTo(filepath.Join(out, manifestName), Opt{}, bytes.NewReader(data), newWriter)
+}
+
+func encryptObject(p *fsutil.Progress, in, out string, key []byte, e *DirEntry) error {
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	opt := Opt{Path: e.Path}
+	return encryptTo(out, opt, p.Reader(f), func(w io.Writer) (io.WriteCloser, error) {
+		return newEncryptWriter(w, &Header{KDF: KDFRaw}, key, opt)
+	})
+}
+
+// ReadDirManifest 解密 EncryptDir 输出的清单
+func ReadDirManifest(in string, keys *Keys) (*DirManifest, error) {
+	return readDirManifest(context.Background(), in, keys)
+}
+
+func readDirManifest(ctx context.Context, in string, keys *Keys) (*DirManifest, error) {
+	f, err := os.Open(filepath.Join(in, manifestName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := newDecryptReader(ctx, f, keys)
+	if err != nil {
+		return nil, err
+	}
+	var m DirManifest
+	if err = json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	// 读完剩余数据, 确保最后一块通过认证
+	if _, err = io.Copy(io.Discard, r); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// DecryptDir 恢复 EncryptDir 加密的目录到 out, 包括 mode 和 mtime.
+// 清单中的绝对路径或包含 .. 的路径返回 ErrUnsafePath
+func DecryptDir(in, out string, keys *Keys) (*DirManifest, error) {
+	return DecryptDirContext(context.Background(), in, out, keys)
+}
+
+// DecryptDirContext 同 DecryptDir, 每次读取前检查 ctx, 取消后返回 ctx.Err(), 已经恢复的文件不会被删除.
+// opts 中仅 Progress 有效
+func DecryptDirContext(ctx context.Context, in, out string, keys *Keys, opts ...DirOpt) (*DirManifest, error) {
+	opt := getDirOpt(opts...)
+	m, err := readDirManifest(ctx, in, keys)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]string, len(m.Entries))
+	for i, e := range m.Entries {
+		if targets[i], err = localPath(out, e.Path); err != nil {
+			return nil, err
+		}
+		if e.Object != "" && !isObjectName(e.Object) {
+			return nil, ErrUnsafePath
+		}
+	}
+
+	var total int64
+	for _, e := range m.Entries {
+		total += e.Size
+	}
+	progress := fsutil.NewProgress(ctx, total, opt.Progress)
+
+	if err = os.MkdirAll(out, 0755); err != nil {
+		return nil, err
+	}
+	for i, e := range m.Entries {
+		if e.Mode.IsDir() {
+			err = os.MkdirAll(targets[i], 0700)
+		} else {
+			err = decryptObject(progress, filepath.Join(in, e.Object), targets[i], m.Key, e)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	// 目录的 mtime 在写入子文件后设置
+	for i := len(m.Entries) - 1; i >= 0; i-- {
+		e := m.Entries[i]
+		if err = os.Chmod(targets[i], e.Mode.Perm()); err != nil {
+			return nil, err
+		}
+		if err = os.Chtimes(targets[i], e.ModTime, e.ModTime); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func decryptObject(p *fsutil.Progress, in, out string, key []byte, e *DirEntry) error {
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := NewDecryptReader(f, key)
+	if err != nil {
+		return err
+	}
+	// 防止对象被替换
+	if r.Path() != e.Path {
+		return ErrManifestMismatch
+	}
+	if err = os.MkdirAll(filepath.Dir(out), 0700); err != nil {
+		return err
+	}
+	outFile, err := openOutput(out, Opt{})
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	n, err := io.CopyBuffer(outFile, p.Reader(r), make([]byte, bufLen))
+	if err != nil {
+		return err
+	}
+	if n != e.Size {
+		return ErrManifestMismatch
+	}
+	return outFile.Commit()
+}
+
+// 检查清单中的相对路径, 返回 root 下的路径
+func localPath(root, p string) (string, error) {
+	if p == "" || path.IsAbs(p) {
+		return "", ErrUnsafePath
+	}
+	for _, s := range strings.Split(p, "/") {
+		if s == "" || s == "." || s == ".." {
+			return "", ErrUnsafePath
+		}
+	}
+	fp := filepath.FromSlash(p)
+	if filepath.IsAbs(fp) || filepath.VolumeName(fp) != "" ||
+		(filepath.Separator != '/' && strings.ContainsRune(p, filepath.Separator)) {
+		return "", ErrUnsafePath
+	}
+	return filepath.Join(root, fp), nil
+}
+
+func newObjectName() (string, error) {
+	b, err := GenAesKey(16)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func isObjectName(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 16
+}
@@ -0,0 +1,135 @@
+package aes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptDir(t *testing.T) {
+	dir := t.TempDir()
+	in, out, dec := filepath.Join(dir, "in"), filepath.Join(dir, "out"), filepath.Join(dir, "dec")
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := map[string][]byte{
+		"a.txt":         []byte("hello"),
+		"sub/b.txt":     bytes.Repeat([]byte("world"), 100000),
+		"sub/.hidden":   []byte("hidden"),
+		".git/config":   []byte("config"),
+		"sub/empty.txt": {},
+	}
+	for name, data := range files {
+		p := filepath.Join(in, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, data, 0640))
+		require.NoError(t, os.Chtimes(p, mtime, mtime))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(in, "empty"), 0700))
+
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+	newWriter := func(w io.Writer) (io.WriteCloser, error) { return NewEncryptWriter(w, key) }
+	require.NoError(t, EncryptDir(in, out, newWriter))
+
+	m, err := DecryptDir(out, dec, &Keys{Key: key})
+	require.NoError(t, err)
+	require.Len(t, m.Entries, 8)
+	for name, data := range files {
+		p := filepath.Join(dec, name)
+		requireFile(t, p, data)
+		fi, err := os.Stat(p)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+		require.True(t, fi.ModTime().Equal(mtime))
+	}
+	fi, err := os.Stat(filepath.Join(dec, "empty"))
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+	require.Equal(t, os.FileMode(0700), fi.Mode().Perm())
+
+	_, err = DecryptDir(out, dec, &Keys{Key: make([]byte, 32)})
+	require.ErrorIs(t, err, ErrAuth)
+
+	// 跳过隐藏文件
+	require.NoError(t, os.RemoveAll(out))
+	require.NoError(t, EncryptDir(in, out, newWriter, DirOpt{SkipHidden: true}))
+	m, err = ReadDirManifest(out, &Keys{Key: key})
+	require.NoError(t, err)
+	var paths []string
+	for _, e := range m.Entries {
+		paths = append(paths, e.Path)
+	}
+	require.ElementsMatch(t, []string{"a.txt", "empty", "sub", "sub/b.txt", "sub/empty.txt"}, paths)
+
+	// 替换对象
+	var a, b string
+	for _, e := range m.Entries {
+		switch e.Path {
+		case "a.txt":
+			a = e.Object
+		case "sub/b.txt":
+			b = e.Object
+		}
+	}
+	require.NoError(t, os.Rename(filepath.Join(out, b), filepath.Join(out, a)))
+	_, err = DecryptDir(out, filepath.Join(dir, "dec2"), &Keys{Key: key})
+	require.ErrorIs(t, err, ErrManifestMismatch)
+}
+
+func TestEncryptDirContext(t *testing.T) {
+	dir := t.TempDir()
+	in, out, dec := filepath.Join(dir, "in"), filepath.Join(dir, "out"), filepath.Join(dir, "dec")
+	require.NoError(t, os.MkdirAll(filepath.Join(in, "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(in, "a.txt"), bytes.Repeat([]byte("a"), 100*1024), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(in, "sub/b.txt"), bytes.Repeat([]byte("b"), 50*1024), 0600))
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+	newWriter := func(w io.Writer) (io.WriteCloser, error) { return NewEncryptWriter(w, key) }
+
+	// 进度按所有文件累计
+	var done, total int64
+	progress := func(d, t int64) { done, total = d, t }
+	require.NoError(t, EncryptDirContext(context.Background(), in, out, newWriter, DirOpt{Progress: progress}))
+	require.Equal(t, int64(150*1024), total)
+	require.Equal(t, total, done)
+	done, total = 0, 0
+	_, err = DecryptDirContext(context.Background(), out, dec, &Keys{Key: key}, DirOpt{Progress: progress})
+	require.NoError(t, err)
+	require.Equal(t, int64(150*1024), total)
+	require.Equal(t, total, done)
+
+	// 取消后不写入清单
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	out2 := filepath.Join(dir, "out2")
+	require.ErrorIs(t, EncryptDirContext(ctx, in, out2, newWriter), context.Canceled)
+	_, err = os.Stat(filepath.Join(out2, manifestName))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = DecryptDirContext(ctx, out, filepath.Join(dir, "dec2"), &Keys{Key: key})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestDecryptDirUnsafePath(t *testing.T) {
+	dir := t.TempDir()
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+
+	for _, p := range []string{"../evil", "a/../../evil", "/etc/evil", "", "a//b", "./a"} {
+		m := DirManifest{Key: key, Entries: []*DirEntry{{Path: p, Mode: os.ModeDir | 0755}}}
+		data, err := json.Marshal(&m)
+		require.NoError(t, err)
+		err = encryptTo(filepath.Join(dir, manifestName), Opt{}, bytes.NewReader(data), func(w io.Writer) (io.WriteCloser, error) {
+			return NewEncryptWriter(w, key)
+		})
+		require.NoError(t, err)
+
+		_, err = DecryptDir(dir, filepath.Join(dir, "dec"), &Keys{Key: key})
+		require.ErrorIs(t, err, ErrUnsafePath, p)
+	}
+}
@@ -0,0 +1,315 @@
+package aes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+/*
+加密文件格式:
+
+	magic(8) | version(1) | cipher(1) | kdf(1) | flags(1) | bodyLen(4, BE) | body
+	body: 若干字段, 每个字段为 tag(1) | len(2, BE) | value
+
+没有 magic 的文件按旧格式处理.
+*/
+
+var (
+	// ErrNoHeader 文件没有格式头, 即旧格式文件
+	ErrNoHeader = errors.New("no header")
+	// ErrInvalidHeader 文件头损坏
+	ErrInvalidHeader = errors.New("invalid header")
+	// ErrUnsupported 不支持的版本或算法
+	ErrUnsupported = errors.New("unsupported header")
+)
+
+var magic = []byte("HXAES\x00\r\n")
+
+const (
+	// Version1 当前文件格式版本
+	Version1 byte = 1
+
+	fixedHeaderLen = 16
+	maxHeaderLen   = 1 << 20
+)
+
+// CipherID 内容加密算法
+type CipherID byte
+
+const (
+	// CipherAES256CTR AES-256-CTR, 无认证
+	CipherAES256CTR CipherID = 1
+	// CipherAES256GCMChunk 分块 AES-256-GCM, 见 aes_chunk.go
+	CipherAES256GCMChunk CipherID = 2
+)
+
+// KDFID 内容密钥的来源
+type KDFID byte
+
+const (
+	// KDFRaw 直接使用调用方提供的 key
+	KDFRaw KDFID = 0
+	// KDFRSA 随机 key, 经 keyKey(GCM) 和 RSA(PKCS#1 v1.5) 加密后存于文件头
+	KDFRSA KDFID = 1
+	// KDFArgon2id 从口令派生 key, 参数存于文件头
+	KDFArgon2id KDFID = 2
+	// KDFScrypt 从口令派生 key, 参数存于文件头
+	KDFScrypt KDFID = 3
+	// KDFPBKDF2 从口令派生 key(HMAC-SHA256), 参数存于文件头
+	KDFPBKDF2 KDFID = 4
+	// KDFRSAOAEP 同 KDFRSA, RSA 使用 OAEP(SHA-256)
+	KDFRSAOAEP KDFID = 5
+	// KDFX25519 随机 key, 经 X25519 协商的密钥加密后存于文件头, 见 x25519.go
+	KDFX25519 KDFID = 6
+	// KDFRecipients 随机 key, 分别为多个接收方加密后存于文件头, 见 recipient.go
+	KDFRecipients KDFID = 7
+	// KDFProvider 随机 key, 经 KeyProvider 加密后存于文件头, KeyID 为 provider 的密钥标识, 见 keyprovider.go
+	KDFProvider KDFID = 8
+)
+
+// Flags 文件头标志位
+type Flags byte
+
+const (
+	// FlagFixedIV iv 取自 key, 不写入文件头
+	FlagFixedIV Flags = 1 << iota
+	// FlagPath 文件头中包含加密后的原始路径
+	FlagPath
+)
+
+// 字段 tag
+const (
+	tagKeyID      byte = 1
+	tagIV         byte = 2
+	tagWrappedKey byte = 3
+	tagPath       byte = 4
+	tagSalt       byte = 5
+	tagChunkSize  byte = 6
+	tagKDFParams  byte = 7
+	tagEphemeral  byte = 8
+	tagRecipient  byte = 9
+	tagPart       byte = 10
+	tagCodec      byte = 11
+)
+
+type field struct {
+	tag   byte
+	value []byte
+}
+
+// Header 加密文件头
+type Header struct {
+	Version    byte
+	Cipher     CipherID
+	KDF        KDFID
+	Flags      Flags
+	KeyID      string // 密钥标识, 可为空
+	IV         []byte
+	WrappedKey []byte // 被加密的内容密钥
+	Path       []byte // 被加密的原始路径
+	Salt       []byte // 分块模式派生块密钥的随机 salt
+	ChunkSize  uint32 // 分块模式的明文块大小
+	KDFParams  *KDFParams
+	Ephemeral  []byte // X25519 模式的临时公钥
+	Recipients []*Stanza
+	Part       *PartInfo // 分片加密时的分片信息
+	Codec      Codec     // 加密前的压缩算法, 见 compress.go
+
+	size int
+}
+
+// Size 文件头长度, 即密文在文件中的偏移
+func (h *Header) Size() int {
+	return h.size
+}
+
+// MarshalBinary 序列化文件头
+func (h *Header) MarshalBinary() ([]byte, error) {
+	var body []byte
+	var err error
+	var chunkSize, kdfParams, part []byte
+	if h.ChunkSize > 0 {
+		chunkSize = binary.BigEndian.AppendUint32(nil, h.ChunkSize)
+	}
+	if h.KDFParams != nil {
+		if kdfParams, err = h.KDFParams.MarshalBinary(); err != nil {
+			return nil, err
+		}
+	}
+	if h.Part != nil {
+		if part, err = h.Part.marshal(); err != nil {
+			return nil, err
+		}
+	}
+	var codec []byte
+	if h.Codec != CodecNone {
+		codec = []byte{byte(h.Codec)}
+	}
+	fields := []field{
+		{tagKeyID, []byte(h.KeyID)},
+		{tagIV, h.IV},
+		{tagWrappedKey, h.WrappedKey},
+		{tagPath, h.Path},
+		{tagSalt, h.Salt},
+		{tagChunkSize, chunkSize},
+		{tagKDFParams, kdfParams},
+		{tagEphemeral, h.Ephemeral},
+		{tagPart, part},
+		{tagCodec, codec},
+	}
+	for _, r := range h.Recipients {
+		fields = append(fields, field{tagRecipient, r.marshal()})
+	}
+	for _, f := range fields {
+		if body, err = appendField(body, f.tag, f.value); err != nil {
+			return nil, err
+		}
+	}
+	if fixedHeaderLen+len(body) > maxHeaderLen {
+		return nil, ErrInvalidHeader
+	}
+
+	buf := make([]byte, 0, fixedHeaderLen+len(body))
+	buf = append(buf, magic...)
+	buf = append(buf, h.Version, byte(h.Cipher), byte(h.KDF), byte(h.Flags))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(body)))
+	buf = append(buf, body...)
+	h.size = len(buf)
+	return buf, nil
+}
+
+// 需要认证的文件头数据, 不包含被加密的内容密钥, 以便替换密钥而不影响密文
+func (h *Header) authData() ([]byte, error) {
+	tmp := *h
+	tmp.WrappedKey, tmp.Ephemeral, tmp.Recipients = nil, nil, nil
+	return tmp.MarshalBinary()
+}
+
+// WriteTo 写入文件头
+func (h *Header) WriteTo(w io.Writer) (int64, error) {
+	buf, err := h.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// ReadHeader 读取文件头, 没有 magic 时返回 ErrNoHeader
+func ReadHeader(r io.Reader) (*Header, error) {
+	fixed := make([]byte, fixedHeaderLen)
+	if _, err := io.ReadFull(r, fixed[:len(magic)]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrNoHeader
+		}
+		return nil, err
+	}
+	if !bytes.Equal(fixed[:len(magic)], magic) {
+		return nil, ErrNoHeader
+	}
+	if _, err := io.ReadFull(r, fixed[len(magic):]); err != nil {
+		return nil, ErrInvalidHeader
+	}
+
+	h := Header{
+		Version: fixed[8],
+		Cipher:  CipherID(fixed[9]),
+		KDF:     KDFID(fixed[10]),
+		Flags:   Flags(fixed[11]),
+	}
+	if h.Version != Version1 {
+		return nil, ErrUnsupported
+	}
+	bodyLen := binary.BigEndian.Uint32(fixed[12:])
+	if bodyLen > maxHeaderLen-fixedHeaderLen {
+		return nil, ErrInvalidHeader
+	}
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, ErrInvalidHeader
+	}
+	if err := h.parseBody(body); err != nil {
+		return nil, err
+	}
+	h.size = fixedHeaderLen + len(body)
+
+	return &h, nil
+}
+
+func (h *Header) parseBody(body []byte) error {
+	for len(body) > 0 {
+		if len(body) < 3 {
+			return ErrInvalidHeader
+		}
+		tag, n := body[0], int(binary.BigEndian.Uint16(body[1:3]))
+		if len(body) < 3+n {
+			return ErrInvalidHeader
+		}
+		value := body[3 : 3+n]
+		body = body[3+n:]
+
+		switch tag {
+		case tagKeyID:
+			h.KeyID = string(value)
+		case tagIV:
+			h.IV = value
+		case tagWrappedKey:
+			h.WrappedKey = value
+		case tagPath:
+			h.Path = value
+		case tagEphemeral:
+			h.Ephemeral = value
+		case tagRecipient:
+			r, err := parseStanza(value)
+			if err != nil {
+				return err
+			}
+			h.Recipients = append(h.Recipients, r)
+		case tagSalt:
+			h.Salt = value
+		case tagChunkSize:
+			if len(value) != 4 {
+				return ErrInvalidHeader
+			}
+			h.ChunkSize = binary.BigEndian.Uint32(value)
+		case tagKDFParams:
+			h.KDFParams = &KDFParams{}
+			if err := h.KDFParams.UnmarshalBinary(value); err != nil {
+				return err
+			}
+		case tagPart:
+			part, err := parsePartInfo(value)
+			if err != nil {
+				return err
+			}
+			h.Part = part
+		case tagCodec:
+			if len(value) != 1 {
+				return ErrInvalidHeader
+			}
+			h.Codec = Codec(value[0])
+		default:
+			return ErrUnsupported
+		}
+	}
+	// 分片加密不压缩
+	if h.Part != nil && h.Codec != CodecNone {
+		return ErrInvalidHeader
+	}
+	return nil
+}
+
+// 空字段不写入
+func appendField(buf []byte, tag byte, value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return buf, nil
+	}
+	if len(value) > 0xffff {
+		return nil, ErrInvalidHeader
+	}
+	buf = append(buf, tag)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(value)))
+	return append(buf, value...), nil
+}
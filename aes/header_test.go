@@ -0,0 +1,146 @@
+package aes
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeader(t *testing.T) {
+	h := &Header{
+		Version:    Version1,
+		Cipher:     CipherAES256CTR,
+		KDF:        KDFRSA,
+		Flags:      FlagPath,
+		KeyID:      "k1",
+		IV:         []byte("0123456789abcdef"),
+		WrappedKey: []byte("wrapped"),
+		Path:       []byte("path"),
+		Salt:       []byte("salt"),
+		ChunkSize:  DefaultChunkSize,
+	}
+	data, err := h.MarshalBinary()
+	require.NoError(t, err)
+
+	h2, err := ReadHeader(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, h, h2)
+	require.Equal(t, len(data), h2.Size())
+
+	_, err = ReadHeader(bytes.NewReader([]byte("hello world")))
+	require.ErrorIs(t, err, ErrNoHeader)
+	_, err = ReadHeader(bytes.NewReader(data[:len(data)-1]))
+	require.ErrorIs(t, err, ErrInvalidHeader)
+}
+
+func TestDecryptAny(t *testing.T) {
+	dir := t.TempDir()
+	in, out, dec := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"), filepath.Join(dir, "dec.txt")
+	data := bytes.Repeat([]byte("hello world"), 10000)
+	require.NoError(t, os.WriteFile(in, data, 0600))
+
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	require.NoError(t, EncryptFile(in, out, key, false))
+	_, err = DecryptAny(out, dec, &Keys{Key: key})
+	require.NoError(t, err)
+	requireFile(t, dec, data)
+
+	require.NoError(t, EncryptFileAndPathWithRSA(in, out, key, &pk.PublicKey))
+	path, err := DecryptAny(out, dec, &Keys{KeyKey: key, PrivateKey: pk})
+	require.NoError(t, err)
+	require.Equal(t, in, path)
+	requireFile(t, dec, data)
+
+	_, err = DecryptAny(out, dec, &Keys{Key: key})
+	require.ErrorIs(t, err, ErrMissingKey)
+}
+
+func TestDecryptAnyLegacy(t *testing.T) {
+	dir := t.TempDir()
+	in, out, dec := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"), filepath.Join(dir, "dec.txt")
+	data := bytes.Repeat([]byte("hello world"), 10000)
+	require.NoError(t, os.WriteFile(in, data, 0600))
+
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	for _, fixedIV := range []bool{true, false} {
+		require.NoError(t, os.WriteFile(out, legacyEncrypt(t, data, key, fixedIV), 0600))
+		_ = os.Remove(dec)
+		require.NoError(t, DecryptFile(out, dec, key, fixedIV))
+		requireFile(t, dec, data)
+	}
+
+	for _, withPath := range []bool{true, false} {
+		require.NoError(t, os.WriteFile(out, legacyEncryptRSA(t, data, in, key, &pk.PublicKey, withPath), 0600))
+		_ = os.Remove(dec)
+		path, err := DecryptAny(out, dec, &Keys{KeyKey: key, PrivateKey: pk})
+		require.NoError(t, err)
+		if withPath {
+			require.Equal(t, in, path)
+		} else {
+			require.Empty(t, path)
+		}
+		requireFile(t, dec, data)
+	}
+}
+
+func requireFile(t *testing.T, path string, data []byte) {
+	got, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+}
+
+func legacyEncrypt(t *testing.T, data, key []byte, fixedIV bool) []byte {
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	iv := key[:block.BlockSize()]
+	if !fixedIV {
+		iv, err = GenAesKey(block.BlockSize())
+		require.NoError(t, err)
+	}
+	enc := make([]byte, len(data))
+	cipher.NewCTR(block, iv).XORKeyStream(enc, data)
+	if !fixedIV {
+		enc = append(enc, iv...)
+	}
+	return enc
+}
+
+func legacyEncryptRSA(t *testing.T, data []byte, path string, keyKey []byte, pk *rsa.PublicKey, withPath bool) []byte {
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+	iv, err := GenAesKey(aes.BlockSize)
+	require.NoError(t, err)
+	wrapped, err := EncryptGCM(append(append([]byte{}, key...), iv...), keyKey)
+	require.NoError(t, err)
+	wrapped, err = RsaEncrypt(wrapped, pk)
+	require.NoError(t, err)
+
+	buf := []byte{byte(len(wrapped)), byte(len(wrapped) >> 8)}
+	buf = append(buf, wrapped...)
+	if withPath {
+		encPath, err := EncryptGCM([]byte(path), keyKey)
+		require.NoError(t, err)
+		buf = append(buf, byte(len(encPath)), byte(len(encPath)>>8))
+		buf = append(buf, encPath...)
+	}
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	enc := make([]byte, len(data))
+	cipher.NewCTR(block, iv).XORKeyStream(enc, data)
+	return append(buf, enc...)
+}
@@ -0,0 +1,195 @@
+package aes
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+)
+
+// KeyType 密钥类型
+type KeyType int
+
+const (
+	// KeyRSA RSA
+	KeyRSA KeyType = iota
+	// KeyECDSAP256 ECDSA P-256
+	KeyECDSAP256
+	// KeyEd25519 Ed25519
+	KeyEd25519
+)
+
+const (
+	pemRSAPrivate       = "RSA PRIVATE KEY"
+	pemRSAPublic        = "RSA PUBLIC KEY"
+	pemECPrivate        = "EC PRIVATE KEY"
+	pemPrivate          = "PRIVATE KEY"
+	pemEncryptedPrivate = "ENCRYPTED PRIVATE KEY"
+	pemPublic           = "PUBLIC KEY"
+	pemCertificate      = "CERTIFICATE"
+
+	defaultRSABits = 4096
+)
+
+var (
+	// ErrUnsupportedKey 不支持的密钥类型
+	ErrUnsupportedKey = errors.New("unsupported key")
+	// ErrPassphraseRequired 私钥被口令加密
+	ErrPassphraseRequired = errors.New("passphrase required")
+)
+
+// GenerateKey 生成私钥, bits 仅用于 RSA, 为 0 时使用 4096
+func GenerateKey(t KeyType, bits int) (crypto.Signer, error) {
+	switch t {
+	case KeyRSA:
+		if bits == 0 {
+			bits = defaultRSABits
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	case KeyECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+// MarshalPrivateKeyPEM 编码为 PKCS#8 PEM, passphrase 不为空时使用 PBES2 加密
+func MarshalPrivateKeyPEM(key crypto.PrivateKey, passphrase []byte) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	block := &pem.Block{Type: pemPrivate, Bytes: der}
+	if len(passphrase) > 0 {
+		if block.Bytes, err = encryptPKCS8(der, passphrase); err != nil {
+			return nil, err
+		}
+		block.Type = pemEncryptedPrivate
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// MarshalPublicKeyPEM 编码为 PKIX PEM
+func MarshalPublicKeyPEM(key crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemPublic, Bytes: der}), nil
+}
+
+// ParsePrivateKeyPEM 根据 PEM 类型解析私钥, 支持 PKCS#1、SEC1、PKCS#8 及口令加密的 PKCS#8
+func ParsePrivateKeyPEM(data, passphrase []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid key")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case pemRSAPrivate:
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case pemECPrivate:
+		return x509.ParseECPrivateKey(block.Bytes)
+	case pemPrivate:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case pemEncryptedPrivate:
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+		der, err2 := decryptPKCS8(block.Bytes, passphrase)
+		if err2 != nil {
+			return nil, err2
+		}
+		// 口令错误时填充仍有约 1/256 的概率合法, 解析失败同样视为口令错误
+		if key, err = x509.ParsePKCS8PrivateKey(der); err != nil {
+			return nil, ErrIncorrectPassphrase
+		}
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	return signer, nil
+}
+
+// ParsePublicKeyPEM 根据 PEM 类型解析公钥, 支持 PKCS#1、PKIX 及证书
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid key")
+	}
+
+	switch block.Type {
+	case pemRSAPublic:
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case pemPublic:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case pemCertificate:
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+// WritePrivateKey 写入 PKCS#8 私钥文件, passphrase 不为空时使用 PBES2 加密
+func WritePrivateKey(path string, key crypto.PrivateKey, passphrase []byte) error {
+	data, err := MarshalPrivateKeyPEM(key, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeOutput(path, data, Opt{})
+}
+
+// WritePublicKey 写入 PKIX 公钥文件
+func WritePublicKey(path string, key crypto.PublicKey) error {
+	data, err := MarshalPublicKeyPEM(key)
+	if err != nil {
+		return err
+	}
+	return writeOutput(path, data, Opt{Perm: 0644})
+}
+
+// ReadAnyPrivateKey 读取任意格式的私钥文件.
+// keyKey 用于 GenRsaPrivateKey 以 GCM 加密的文件, passphrase 用于口令加密的 PKCS#8
+func ReadAnyPrivateKey(keyPath string, keyKey, passphrase []byte) (crypto.Signer, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if keyKey != nil {
+		data, err = DecryptGCM(data, keyKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ParsePrivateKeyPEM(data, passphrase)
+}
+
+// ReadAnyPublicKey 读取任意格式的公钥文件
+func ReadAnyPublicKey(keyPath string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePublicKeyPEM(data)
+}
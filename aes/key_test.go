@@ -0,0 +1,101 @@
+package aes
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateKey(t *testing.T) {
+	for _, kt := range []KeyType{KeyRSA, KeyECDSAP256, KeyEd25519} {
+		key, err := GenerateKey(kt, 2048)
+		require.NoError(t, err)
+
+		for _, passphrase := range [][]byte{nil, []byte("123")} {
+			data, err := MarshalPrivateKeyPEM(key, passphrase)
+			require.NoError(t, err)
+			key2, err := ParsePrivateKeyPEM(data, passphrase)
+			require.NoError(t, err)
+			require.Equal(t, key, key2)
+		}
+
+		data, err := MarshalPublicKeyPEM(key.Public())
+		require.NoError(t, err)
+		pub, err := ParsePublicKeyPEM(data)
+		require.NoError(t, err)
+		require.Equal(t, key.Public(), pub)
+	}
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	key, err := GenerateKey(KeyECDSAP256, 0)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalECPrivateKey(key.(*ecdsa.PrivateKey))
+	require.NoError(t, err)
+	key2, err := ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil)
+	require.NoError(t, err)
+	require.Equal(t, key, key2)
+
+	data, err := MarshalPrivateKeyPEM(key, []byte("123"))
+	require.NoError(t, err)
+	_, err = ParsePrivateKeyPEM(data, nil)
+	require.ErrorIs(t, err, ErrPassphraseRequired)
+	_, err = ParsePrivateKeyPEM(data, []byte("1234"))
+	require.ErrorIs(t, err, ErrIncorrectPassphrase)
+
+	// 填充合法但解密结果不是私钥, 同样是口令错误
+	enc, err := encryptPKCS8([]byte("not a key"), []byte("123"))
+	require.NoError(t, err)
+	_, err = ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: pemEncryptedPrivate, Bytes: enc}), []byte("123"))
+	require.ErrorIs(t, err, ErrIncorrectPassphrase)
+}
+
+func TestReadAnyKey(t *testing.T) {
+	dir := t.TempDir()
+	privatePath, publicPath := filepath.Join(dir, "id"), filepath.Join(dir, "id.pub")
+
+	key, err := GenerateKey(KeyRSA, 2048)
+	require.NoError(t, err)
+	require.NoError(t, WritePrivateKey(privatePath, key, nil))
+	require.NoError(t, WritePublicKey(publicPath, key.Public()))
+
+	// PKCS#8 和 PKIX 格式的 RSA 密钥
+	privateKey, err := ReadPrivateKey(privatePath, nil)
+	require.NoError(t, err)
+	require.Equal(t, key, privateKey)
+	publicKey, err := ReadPublicKey(publicPath)
+	require.NoError(t, err)
+	require.Equal(t, key.Public(), publicKey)
+
+	key, err = GenerateKey(KeyEd25519, 0)
+	require.NoError(t, err)
+	require.NoError(t, WritePrivateKey(privatePath, key, []byte("123")))
+	require.NoError(t, WritePublicKey(publicPath, key.Public()))
+
+	signer, err := ReadAnyPrivateKey(privatePath, nil, []byte("123"))
+	require.NoError(t, err)
+	require.IsType(t, ed25519.PrivateKey{}, signer)
+	pub, err := ReadAnyPublicKey(publicPath)
+	require.NoError(t, err)
+	require.Equal(t, key.Public(), pub)
+
+	_, err = ReadPrivateKey(privatePath, nil)
+	require.Error(t, err)
+	_, err = ReadPublicKey(publicPath)
+	require.ErrorIs(t, err, ErrUnsupportedKey)
+
+	// GenRsaPrivateKey 以 GCM 加密的文件
+	keyKey, err := GenAesKey(32)
+	require.NoError(t, err)
+	require.NoError(t, GenRsaPrivateKey(2048, privatePath, keyKey))
+	signer, err = ReadAnyPrivateKey(privatePath, keyKey, nil)
+	require.NoError(t, err)
+	require.IsType(t, &rsa.PrivateKey{}, signer)
+}
@@ -0,0 +1,249 @@
+package aes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+/*
+KeyProvider 保管主密钥, 只负责加密和解密数据密钥(信封加密), 主密钥不离开 provider.
+内置 provider:
+	Keyring                  内存
+	NewFileKeyProvider       文件, 格式见 ParseKeyring
+	NewEnvKeyProvider        环境变量, 格式见 ParseKeyring
+	HTTPKeyProvider          KMS 风格的 http 服务, 见 kms.go
+信封数据块格式: version(1) | len(keyID)(1) | keyID | len(wrapped)(2) | wrapped | nonce | AES-GCM(数据密钥) 密文,
+version 到 wrapped 以及调用方的 additionalData 参与认证
+文件格式: KDFProvider 文件头, KeyID 和 WrappedKey 分别保存 provider 的密钥标识和被加密的内容密钥
+*/
+
+// KeyProvider 加密和解密数据密钥, 实现需要并发安全
+type KeyProvider interface {
+	// WrapKey 使用当前主密钥加密 key, 返回主密钥标识和密文
+	WrapKey(ctx context.Context, key []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey 使用 keyID 对应的主密钥解密
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+const envelopeVersion = 1
+
+// WrapKey 使用 active 密钥加密 key, 密钥 id 参与认证
+func (k *Keyring) WrapKey(_ context.Context, key []byte) (string, []byte, error) {
+	id, master, err := k.Active()
+	if err != nil {
+		return "", nil, err
+	}
+	wrapped, err := EncryptGCMWithAD(key, master, []byte(id))
+	if err != nil {
+		return "", nil, err
+	}
+	return id, wrapped, nil
+}
+
+// UnwrapKey 使用 keyID 对应的密钥解密
+func (k *Keyring) UnwrapKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	master, err := k.Get(keyID)
+	if err != nil {
+		return nil, err
+	}
+	key, err := DecryptGCMWithAD(wrapped, master, []byte(keyID))
+	if err != nil {
+		return nil, ErrAuth
+	}
+	return key, nil
+}
+
+// ParseKeyring 解析文本格式的 Keyring, 每项为 id:base64(key), 以换行, 空白或逗号分隔,
+// # 开头的行为注释, 最后一项为 active 密钥, 如
+//
+//	v1:3q2+7w...
+//	v2:yv66vg...
+func ParseKeyring(text string) (*Keyring, error) {
+	k := NewKeyring()
+	var active string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, item := range strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		}) {
+			id, encoded, ok := strings.Cut(item, ":")
+			if !ok {
+				return nil, ErrInvalidKeyID
+			}
+			key, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, err
+			}
+			if err = k.Add(id, key); err != nil {
+				return nil, err
+			}
+			active = id
+		}
+	}
+	if active == "" {
+		return nil, ErrNoActiveKey
+	}
+	return k, k.SetActive(active)
+}
+
+// MarshalText 序列化为 ParseKeyring 的格式, 每行一项, active 密钥在最后
+func (k *Keyring) MarshalText() ([]byte, error) {
+	active, _, err := k.Active()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(k.IDs()))
+	for _, id := range k.IDs() {
+		if id != active {
+			ids = append(ids, id)
+		}
+	}
+	var b strings.Builder
+	for _, id := range append(ids, active) {
+		key, err := k.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(id + ":" + base64.StdEncoding.EncodeToString(key) + "\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// UnmarshalText 解析 ParseKeyring 格式, 替换已有的全部密钥
+func (k *Keyring) UnmarshalText(text []byte) error {
+	parsed, err := ParseKeyring(string(text))
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys, k.active = parsed.keys, parsed.active
+	return nil
+}
+
+// NewFileKeyProvider 从文件读取主密钥, 格式见 ParseKeyring
+func NewFileKeyProvider(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeyring(string(data))
+}
+
+// NewEnvKeyProvider 从环境变量读取主密钥, 格式见 ParseKeyring
+func NewEnvKeyProvider(name string) (*Keyring, error) {
+	text, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("env %s not set: %w", name, ErrMissingKey)
+	}
+	return ParseKeyring(text)
+}
+
+// SealEnvelope 生成随机数据密钥加密 plaintext, 数据密钥经 p 加密后与密文保存在一起
+func SealEnvelope(ctx context.Context, p KeyProvider, plaintext, additionalData []byte) ([]byte, error) {
+	key, err := GenAesKey(32)
+	if err != nil {
+		return nil, err
+	}
+	id, wrapped, err := p.WrapKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" || len(id) > 0xff {
+		return nil, ErrInvalidKeyID
+	}
+	if len(wrapped) > 0xffff {
+		return nil, errors.New("wrapped key too long")
+	}
+	prefix := make([]byte, 0, 4+len(id)+len(wrapped))
+	prefix = append(prefix, envelopeVersion, byte(len(id)))
+	prefix = append(prefix, id...)
+	prefix = binary.BigEndian.AppendUint16(prefix, uint16(len(wrapped)))
+	prefix = append(prefix, wrapped...)
+	encrypted, err := EncryptGCMWithAD(plaintext, key, append(prefix[:len(prefix):len(prefix)], additionalData...))
+	if err != nil {
+		return nil, err
+	}
+	return append(prefix, encrypted...), nil
+}
+
+// OpenEnvelope 解密 SealEnvelope 的输出, additionalData 必须与加密时相同
+func OpenEnvelope(ctx context.Context, p KeyProvider, encrypted, additionalData []byte) ([]byte, error) {
+	if len(encrypted) < 2 || encrypted[0] != envelopeVersion || encrypted[1] == 0 {
+		return nil, ErrInvalidCiphertext
+	}
+	n := 2 + int(encrypted[1])
+	if len(encrypted) < n+2 {
+		return nil, ErrInvalidCiphertext
+	}
+	id := string(encrypted[2:n])
+	wrappedLen := int(binary.BigEndian.Uint16(encrypted[n:]))
+	n += 2
+	if len(encrypted) < n+wrappedLen {
+		return nil, ErrInvalidCiphertext
+	}
+	key, err := p.UnwrapKey(ctx, id, encrypted[n:n+wrappedLen])
+	if err != nil {
+		return nil, err
+	}
+	n += wrappedLen
+	plaintext, err := DecryptGCMWithAD(encrypted[n:], key, append(encrypted[:n:n], additionalData...))
+	if err != nil {
+		return nil, ErrAuth
+	}
+	return plaintext, nil
+}
+
+// NewEncryptWriterWithProvider 随机生成 key 加密数据, key 经 p 加密后写入文件头
+func NewEncryptWriterWithProvider(ctx context.Context, w io.Writer, p KeyProvider, opts ...Opt) (io.WriteCloser, error) {
+	h, key, err := newProviderHeader(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptWriter(w, h, key, getOpt(opts...))
+}
+
+// NewDecryptReaderWithProvider 使用 p 解开文件头中的 key
+func NewDecryptReaderWithProvider(ctx context.Context, r io.Reader, p KeyProvider) (*Reader, error) {
+	return newDecryptReader(ctx, r, &Keys{Provider: p})
+}
+
+// EncryptFileWithProvider 随机生成 key 加密文件, key 经 p 加密后写入文件头
+func EncryptFileWithProvider(in, out string, p KeyProvider, opts ...Opt) error {
+	return EncryptFileWithProviderContext(context.Background(), in, out, p, opts...)
+}
+
+func EncryptFileWithProviderContext(ctx context.Context, in, out string, p KeyProvider, opts ...Opt) error {
+	return encryptFile(ctx, in, out, getOpt(opts...), func() (*Header, []byte, error) {
+		return newProviderHeader(ctx, p)
+	})
+}
+
+func DecryptFileWithProvider(in, out string, p KeyProvider, opts ...Opt) error {
+	return DecryptFileWithProviderContext(context.Background(), in, out, p, opts...)
+}
+
+func DecryptFileWithProviderContext(ctx context.Context, in, out string, p KeyProvider, opts ...Opt) error {
+	_, err := decryptAny(ctx, in, out, &Keys{Provider: p}, getOpt(opts...))
+	return err
+}
+
+func newProviderHeader(ctx context.Context, p KeyProvider) (*Header, []byte, error) {
+	key, err := GenAesKey(32)
+	if err != nil {
+		return nil, nil, err
+	}
+	id, wrapped, err := p.WrapKey(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Header{KDF: KDFProvider, KeyID: id, WrappedKey: wrapped}, key, nil
+}
@@ -0,0 +1,121 @@
+package aes
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyring(t *testing.T) {
+	k := newTestKeyring(t, "v2", "v1")
+	text, err := k.MarshalText()
+	require.NoError(t, err)
+
+	parsed, err := ParseKeyring("# keys\n" + string(text))
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1", "v2"}, parsed.IDs())
+	id, key, err := parsed.Active()
+	require.NoError(t, err)
+	require.Equal(t, "v1", id)
+	_, want, err := k.Active()
+	require.NoError(t, err)
+	require.Equal(t, want, key)
+
+	// 环境变量中以逗号分隔
+	t.Setenv("HX_TEST_KEYS", string(bytes.ReplaceAll(bytes.TrimSpace(text), []byte("\n"), []byte(","))))
+	env, err := NewEnvKeyProvider("HX_TEST_KEYS")
+	require.NoError(t, err)
+	require.Equal(t, parsed.IDs(), env.IDs())
+	_, err = NewEnvKeyProvider("HX_TEST_KEYS_NOT_SET")
+	require.ErrorIs(t, err, ErrMissingKey)
+
+	for _, s := range []string{"", "v1", "v1:!", "v1:AAAA", ":" + string(text[3:])} {
+		_, err = ParseKeyring(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestKeyProvider(t *testing.T) {
+	dir := t.TempDir()
+	kr := newTestKeyring(t, "v1")
+	text, err := kr.MarshalText()
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, "keys")
+	require.NoError(t, os.WriteFile(keyFile, text, 0600))
+	fileProvider, err := NewFileKeyProvider(keyFile)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(NewKMSHandler(kr, "token"))
+	defer srv.Close()
+	httpProvider := &HTTPKeyProvider{URL: srv.URL, Token: "token"}
+
+	ctx := context.Background()
+	data := make([]byte, 100*1024)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+	in, out, dec := filepath.Join(dir, "in"), filepath.Join(dir, "out"), filepath.Join(dir, "dec")
+	require.NoError(t, os.WriteFile(in, data, 0600))
+
+	for _, p := range []KeyProvider{kr, fileProvider, httpProvider} {
+		encrypted, err := SealEnvelope(ctx, p, []byte("hello"), []byte("ad"))
+		require.NoError(t, err)
+		plaintext, err := OpenEnvelope(ctx, p, encrypted, []byte("ad"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("hello"), plaintext)
+		_, err = OpenEnvelope(ctx, p, encrypted, nil)
+		require.ErrorIs(t, err, ErrAuth)
+		_, err = OpenEnvelope(ctx, p, encrypted[:10], []byte("ad"))
+		require.Error(t, err)
+
+		require.NoError(t, EncryptFileWithProvider(in, out, p, Opt{Path: in}))
+		require.NoError(t, DecryptFileWithProvider(out, dec, p))
+		requireFile(t, dec, data)
+		require.NoError(t, EncryptFileWithProviderContext(ctx, in, out, p, Opt{Path: in}))
+		require.NoError(t, DecryptFileWithProviderContext(ctx, out, dec, p))
+		requireFile(t, dec, data)
+
+		var buf bytes.Buffer
+		w, err := NewEncryptWriterWithProvider(ctx, &buf, p)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		r, err := NewDecryptReaderWithProvider(ctx, &buf, p)
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, data, got)
+	}
+
+	// 轮换主密钥后旧数据仍可解密
+	encrypted, err := SealEnvelope(ctx, httpProvider, []byte("hello"), nil)
+	require.NoError(t, err)
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+	require.NoError(t, kr.Rotate("v2", key))
+	plaintext, err := OpenEnvelope(ctx, httpProvider, encrypted, nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), plaintext)
+	_, err = DecryptAny(out, dec, &Keys{Provider: kr})
+	require.NoError(t, err)
+	_, err = DecryptAny(out, dec, &Keys{})
+	require.ErrorIs(t, err, ErrMissingKey)
+
+	// token 错误
+	_, _, err = (&HTTPKeyProvider{URL: srv.URL, Token: "bad"}).WrapKey(ctx, key)
+	require.Error(t, err)
+
+	// 请求过大
+	_, _, err = httpProvider.WrapKey(ctx, make([]byte, maxKMSRequest))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "413")
+	_, err = httpProvider.UnwrapKey(ctx, "v3", encrypted)
+	require.Error(t, err)
+}
@@ -0,0 +1,303 @@
+package aes
+
+import (
+	"context"
+	"crypto/aes"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+/*
+Keyring 带 id 的多版本密钥, 新数据使用 active 密钥加密, 解密时按密文中的 id 选择密钥.
+数据块格式: version(1) | len(id)(1) | id | nonce | AES-GCM 密文, version, id 和调用方的 additional data 参与认证
+文件格式: KDFRaw 文件头的 KeyID 记录密钥 id
+*/
+
+var (
+	// ErrUnknownKeyID 密钥 id 不存在
+	ErrUnknownKeyID = errors.New("unknown key id")
+	// ErrInvalidKeyID 密钥 id 为空或超过 255 字节
+	ErrInvalidKeyID = errors.New("invalid key id")
+	// ErrNoActiveKey 没有设置 active 密钥
+	ErrNoActiveKey = errors.New("no active key")
+	// ErrInvalidCiphertext 密文格式错误
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+)
+
+const keyringVersion = 1
+
+// Keyring 并发安全
+type Keyring struct {
+	mu     sync.RWMutex
+	keys   map[string][]byte
+	active string
+}
+
+// NewKeyring 返回空的 Keyring, 需要 Rotate 或 Add 后 SetActive 才能加密
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string][]byte)}
+}
+
+// Add 增加密钥, key 必须是 16, 24 或 32 字节, 已存在的 id 会被覆盖
+func (k *Keyring) Add(id string, key []byte) error {
+	if id == "" || len(id) > 0xff {
+		return ErrInvalidKeyID
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = append([]byte(nil), key...)
+	return nil
+}
+
+// Rotate 增加密钥并设置为 active, 旧密钥保留用于解密
+func (k *Keyring) Rotate(id string, key []byte) error {
+	if err := k.Add(id, key); err != nil {
+		return err
+	}
+	return k.SetActive(id)
+}
+
+// SetActive 设置加密使用的密钥
+func (k *Keyring) SetActive(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return ErrUnknownKeyID
+	}
+	k.active = id
+	return nil
+}
+
+// Remove 删除密钥, 不能删除 active 密钥. 删除后使用该密钥的数据无法解密
+func (k *Keyring) Remove(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return ErrUnknownKeyID
+	}
+	if id == k.active {
+		return errors.New("cannot remove active key")
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+// Active 返回 active 密钥
+func (k *Keyring) Active() (string, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.active == "" {
+		return "", nil, ErrNoActiveKey
+	}
+	return k.active, k.keys[k.active], nil
+}
+
+// Get 按 id 返回密钥
+func (k *Keyring) Get(id string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+	return key, nil
+}
+
+// IDs 返回排序后的所有密钥 id
+func (k *Keyring) IDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Encrypt 使用 active 密钥加密
+func (k *Keyring) Encrypt(plaintext []byte) ([]byte, error) {
+	return k.EncryptWithAD(plaintext, nil)
+}
+
+// EncryptWithAD 使用 active 密钥加密, additionalData 参与认证但不保存, 解密时必须提供相同的值
+func (k *Keyring) EncryptWithAD(plaintext, additionalData []byte) ([]byte, error) {
+	id, key, err := k.Active()
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, 0, 2+len(id))
+	prefix = append(prefix, keyringVersion, byte(len(id)))
+	prefix = append(prefix, id...)
+	encrypted, err := EncryptGCMWithAD(plaintext, key, append(prefix[:len(prefix):len(prefix)], additionalData...))
+	if err != nil {
+		return nil, err
+	}
+	return append(prefix, encrypted...), nil
+}
+
+// Decrypt 使用密文中 id 对应的密钥解密
+func (k *Keyring) Decrypt(encrypted []byte) ([]byte, error) {
+	return k.DecryptWithAD(encrypted, nil)
+}
+
+// DecryptWithAD 解密 EncryptWithAD 的输出
+func (k *Keyring) DecryptWithAD(encrypted, additionalData []byte) ([]byte, error) {
+	id, err := KeyIDOf(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	key, err := k.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	n := 2 + len(id)
+	plaintext, err := DecryptGCMWithAD(encrypted[n:], key, append(encrypted[:n:n], additionalData...))
+	if err != nil {
+		return nil, ErrAuth
+	}
+	return plaintext, nil
+}
+
+// Rewrap 使用 active 密钥重新加密, 已经是 active 密钥的密文原样返回
+func (k *Keyring) Rewrap(encrypted []byte) ([]byte, error) {
+	id, err := KeyIDOf(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if active, _, err := k.Active(); err == nil && active == id {
+		return encrypted, nil
+	}
+	plaintext, err := k.Decrypt(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	return k.Encrypt(plaintext)
+}
+
+// KeyIDOf 返回 Keyring.Encrypt 密文使用的密钥 id
+func KeyIDOf(encrypted []byte) (string, error) {
+	if len(encrypted) < 2 || encrypted[0] != keyringVersion || encrypted[1] == 0 || len(encrypted) < 2+int(encrypted[1]) {
+		return "", ErrInvalidCiphertext
+	}
+	return string(encrypted[2 : 2+int(encrypted[1])]), nil
+}
+
+// NewEncryptWriterWithKeyring 使用 active 密钥加密, 密钥 id 写入文件头
+func NewEncryptWriterWithKeyring(w io.Writer, k *Keyring, opts ...Opt) (io.WriteCloser, error) {
+	h, key, err := k.newHeader()
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptWriter(w, h, key, getOpt(opts...))
+}
+
+// NewDecryptReaderWithKeyring 使用文件头中 id 对应的密钥解密
+func NewDecryptReaderWithKeyring(r io.Reader, k *Keyring) (*Reader, error) {
+	return NewDecryptReaderAny(r, &Keys{Keyring: k})
+}
+
+// EncryptFileWithKeyring 使用 active 密钥加密文件
+func EncryptFileWithKeyring(in, out string, k *Keyring, opts ...Opt) error {
+	return EncryptFileWithKeyringContext(context.Background(), in, out, k, opts...)
+}
+
+func EncryptFileWithKeyringContext(ctx context.Context, in, out string, k *Keyring, opts ...Opt) error {
+	return encryptFile(ctx, in, out, getOpt(opts...), k.newHeader)
+}
+
+func DecryptFileWithKeyring(in, out string, k *Keyring, opts ...Opt) error {
+	_, err := DecryptAny(in, out, &Keys{Keyring: k}, opts...)
+	return err
+}
+
+// RewrapFile 使用 active 密钥重新加密文件, 保留文件头中的原始路径, 压缩方式和分片大小, out 不能与 in 相同.
+// 文件已经使用 active 密钥时原样复制
+func (k *Keyring) RewrapFile(in, out string) error {
+	if in == out {
+		return ErrSameFile
+	}
+	inFile, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+
+	h, err := ReadHeader(inFile)
+	if err != nil {
+		return err
+	}
+	if _, err = inFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	id, _, err := k.Active()
+	if err != nil {
+		return err
+	}
+	if h.KDF == KDFRaw && h.KeyID == id {
+		return copyTo(out, inFile)
+	}
+	if h.Part != nil {
+		return k.rewrapParts(inFile, h, out)
+	}
+
+	r, err := NewDecryptReaderWithKeyring(inFile, k)
+	if err != nil {
+		return err
+	}
+	opt := Opt{Path: r.Path(), Compress: h.Codec}
+	return encryptTo(out, Opt{}, r, func(w io.Writer) (io.WriteCloser, error) {
+		return NewEncryptWriterWithKeyring(w, k, opt)
+	})
+}
+
+// 分片文件按原来的分片大小重新加密, h 为第一片的文件头
+func (k *Keyring) rewrapParts(inFile *os.File, h *Header, out string) error {
+	fi, err := inFile.Stat()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	key, path, err := (&Keys{Keyring: k}).open(ctx, h)
+	if err != nil {
+		return err
+	}
+	p, err := newPartsReaderAt(inFile, fi.Size(), h, key)
+	if err != nil {
+		return err
+	}
+	// 空文件只有一片且分片大小为 0, 分片大小不影响结果
+	split := h.Part.Size
+	if split == 0 {
+		split = 1
+	}
+	return encryptParts(ctx, p, p.size, out, Opt{Path: path, SplitSize: split}, k.newHeader)
+}
+
+// 原样复制已经使用活动密钥的文件
+func copyTo(out string, r io.Reader) error {
+	outFile, err := openOutput(out, Opt{})
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	if _, err = io.CopyBuffer(outFile, r, make([]byte, bufLen)); err != nil {
+		return err
+	}
+	return outFile.Commit()
+}
+
+func (k *Keyring) newHeader() (*Header, []byte, error) {
+	id, key, err := k.Active()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Header{KDF: KDFRaw, KeyID: id}, key, nil
+}
@@ -0,0 +1,197 @@
+package aes
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKeyring(t *testing.T, ids ...string) *Keyring {
+	k := NewKeyring()
+	for _, id := range ids {
+		key, err := GenAesKey(32)
+		require.NoError(t, err)
+		require.NoError(t, k.Rotate(id, key))
+	}
+	return k
+}
+
+func TestKeyring(t *testing.T) {
+	k := NewKeyring()
+	_, err := k.Encrypt([]byte("hello"))
+	require.ErrorIs(t, err, ErrNoActiveKey)
+	require.ErrorIs(t, k.Add("", make([]byte, 32)), ErrInvalidKeyID)
+	require.Error(t, k.Add("v1", make([]byte, 10)))
+
+	k = newTestKeyring(t, "v1")
+	old, err := k.Encrypt([]byte("hello"))
+	require.NoError(t, err)
+	id, err := KeyIDOf(old)
+	require.NoError(t, err)
+	require.Equal(t, "v1", id)
+
+	// 轮换后旧数据仍可解密, 新数据使用新密钥
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+	require.NoError(t, k.Rotate("v2", key))
+	require.Equal(t, []string{"v1", "v2"}, k.IDs())
+	dec, err := k.Decrypt(old)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), dec)
+
+	rewrapped, err := k.Rewrap(old)
+	require.NoError(t, err)
+	id, err = KeyIDOf(rewrapped)
+	require.NoError(t, err)
+	require.Equal(t, "v2", id)
+	dec, err = k.Decrypt(rewrapped)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), dec)
+
+	// 修改 id 无法通过认证
+	tampered := append([]byte(nil), rewrapped...)
+	tampered[2] = '1'
+	tampered[3] = '2'
+	require.NoError(t, k.Add("12", key))
+	_, err = k.Decrypt(tampered)
+	require.ErrorIs(t, err, ErrAuth)
+
+	require.Error(t, k.Remove("v2"))
+	require.NoError(t, k.Remove("v1"))
+	_, err = k.Decrypt(old)
+	require.ErrorIs(t, err, ErrUnknownKeyID)
+}
+
+func TestKeyringAD(t *testing.T) {
+	k := newTestKeyring(t, "v1")
+	encrypted, err := k.EncryptWithAD([]byte("hello"), []byte("user/email/1"))
+	require.NoError(t, err)
+	dec, err := k.DecryptWithAD(encrypted, []byte("user/email/1"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), dec)
+
+	// additional data 不同时无法解密
+	_, err = k.DecryptWithAD(encrypted, []byte("user/email/2"))
+	require.ErrorIs(t, err, ErrAuth)
+	_, err = k.Decrypt(encrypted)
+	require.ErrorIs(t, err, ErrAuth)
+
+	// 没有 additional data 时与 Encrypt 相同
+	encrypted, err = k.Encrypt([]byte("hello"))
+	require.NoError(t, err)
+	dec, err = k.DecryptWithAD(encrypted, nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), dec)
+}
+
+func TestRewrapFile(t *testing.T) {
+	dir := t.TempDir()
+	in, out, out2, dec := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"),
+		filepath.Join(dir, "out2.txt"), filepath.Join(dir, "dec.txt")
+	data := []byte("hello world")
+	require.NoError(t, os.WriteFile(in, data, 0600))
+
+	k := newTestKeyring(t, "v1")
+	require.NoError(t, EncryptFileWithKeyring(in, out, k, Opt{Path: in}))
+
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+	require.NoError(t, k.Rotate("v2", key))
+	require.NoError(t, k.RewrapFile(out, out2))
+	require.ErrorIs(t, k.RewrapFile(out, out), ErrSameFile)
+	require.NoError(t, k.Remove("v1"))
+
+	f, err := os.Open(out2)
+	require.NoError(t, err)
+	h, err := ReadHeader(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, "v2", h.KeyID)
+
+	path, err := DecryptAny(out2, dec, &Keys{Keyring: k})
+	require.NoError(t, err)
+	require.Equal(t, in, path)
+	requireFile(t, dec, data)
+
+	require.ErrorIs(t, DecryptFileWithKeyring(out, dec, k), ErrUnknownKeyID)
+}
+
+func TestRewrapFileKeepOpt(t *testing.T) {
+	dir := t.TempDir()
+	in, out, out2, dec := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"),
+		filepath.Join(dir, "out2.txt"), filepath.Join(dir, "dec.txt")
+	data := bytes.Repeat([]byte("hello world"), 10000)
+	require.NoError(t, os.WriteFile(in, data, 0600))
+
+	readHeader := func(path string) *Header {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+		h, err := ReadHeader(f)
+		require.NoError(t, err)
+		return h
+	}
+	for _, opt := range []Opt{{Path: in, Compress: CodecZstd}, {Path: in, SplitSize: 16 * 1024}} {
+		k := newTestKeyring(t, "v1")
+		require.NoError(t, EncryptFileWithKeyring(in, out, k, opt))
+
+		// 已经使用 active 密钥时原样复制
+		require.NoError(t, k.RewrapFile(out, out2))
+		enc, err := os.ReadFile(out)
+		require.NoError(t, err)
+		requireFile(t, out2, enc)
+
+		key, err := GenAesKey(32)
+		require.NoError(t, err)
+		require.NoError(t, k.Rotate("v2", key))
+		require.NoError(t, k.RewrapFile(out, out2))
+		require.NoError(t, k.Remove("v1"))
+
+		h := readHeader(out2)
+		require.Equal(t, "v2", h.KeyID)
+		require.Equal(t, opt.Compress, h.Codec)
+		if opt.SplitSize > 0 {
+			require.NotNil(t, h.Part)
+			require.Equal(t, opt.SplitSize, h.Part.Size)
+			require.Equal(t, uint32(len(data)/int(opt.SplitSize)+1), h.Part.Count)
+		}
+		path, err := DecryptAny(out2, dec, &Keys{Keyring: k})
+		require.NoError(t, err)
+		require.Equal(t, in, path)
+		requireFile(t, dec, data)
+	}
+}
+
+func TestRewrapFileSinglePart(t *testing.T) {
+	dir := t.TempDir()
+	in, out, out2, dec := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"),
+		filepath.Join(dir, "out2.txt"), filepath.Join(dir, "dec.txt")
+	// 只有一片的分片文件, 包括空文件
+	for _, data := range [][]byte{{}, []byte("hello world")} {
+		require.NoError(t, os.WriteFile(in, data, 0600))
+		k := newTestKeyring(t, "v1")
+		require.NoError(t, EncryptFileWithKeyring(in, out, k, Opt{Path: in, SplitSize: 16 * 1024}))
+		key, err := GenAesKey(32)
+		require.NoError(t, err)
+		require.NoError(t, k.Rotate("v2", key))
+		require.NoError(t, k.RewrapFile(out, out2))
+
+		f, err := os.Open(out2)
+		require.NoError(t, err)
+		h, err := ReadHeader(f)
+		f.Close()
+		require.NoError(t, err)
+		require.Equal(t, "v2", h.KeyID)
+		require.NotNil(t, h.Part)
+		require.Equal(t, uint32(1), h.Part.Count)
+		require.Equal(t, int64(len(data)), h.Part.Size)
+
+		require.NoError(t, k.Remove("v1"))
+		_, err = DecryptAny(out2, dec, &Keys{Keyring: k})
+		require.NoError(t, err)
+		requireFile(t, dec, data)
+	}
+}
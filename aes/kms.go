@@ -0,0 +1,152 @@
+package aes
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+/*
+KMS 风格的 http 接口, 请求和响应均为 JSON, []byte 字段为 base64 编码:
+	POST /wrap    {"plaintext": key}                      -> {"key_id": id, "ciphertext": wrapped}
+	POST /unwrap  {"key_id": id, "ciphertext": wrapped}   -> {"plaintext": key}
+失败时返回非 200 状态码和 {"error": "..."}, 设置 token 时请求需要带 Authorization: Bearer token
+*/
+
+// 请求只包含密钥和 id, 限制请求大小
+const maxKMSRequest = 64 << 10
+
+type kmsRequest struct {
+	KeyID      string `json:"key_id,omitempty"`
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+}
+
+type kmsResponse struct {
+	KeyID      string `json:"key_id,omitempty"`
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// HTTPKeyProvider 通过 http 调用 KMS 加密和解密数据密钥, 主密钥保存在服务端
+type HTTPKeyProvider struct {
+	URL    string       // 服务地址, 如 https://kms.example.com/v1
+	Token  string       // 不为空时作为 Bearer token
+	Client *http.Client // 为空时使用 http.DefaultClient
+}
+
+func (p *HTTPKeyProvider) WrapKey(ctx context.Context, key []byte) (string, []byte, error) {
+	resp, err := p.call(ctx, "/wrap", &kmsRequest{Plaintext: key})
+	if err != nil {
+		return "", nil, err
+	}
+	if resp.KeyID == "" || len(resp.Ciphertext) == 0 {
+		return "", nil, errors.New("kms: empty wrap response")
+	}
+	return resp.KeyID, resp.Ciphertext, nil
+}
+
+func (p *HTTPKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	resp, err := p.call(ctx, "/unwrap", &kmsRequest{KeyID: keyID, Ciphertext: wrapped})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Plaintext) == 0 {
+		return nil, errors.New("kms: empty unwrap response")
+	}
+	return resp.Plaintext, nil
+}
+
+func (p *HTTPKeyProvider) call(ctx context.Context, path string, req *kmsRequest) (*kmsResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(p.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	var resp kmsResponse
+	if err = json.NewDecoder(httpResp.Body).Decode(&resp); err != nil && httpResp.StatusCode == http.StatusOK {
+		return nil, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kms: %s %s", httpResp.Status, resp.Error)
+	}
+	return &resp, nil
+}
+
+// NewKMSHandler 返回 HTTPKeyProvider 对应的服务端, 使用 p 加密和解密, 可作为本地 KMS 或测试使用.
+// token 不为空时校验 Bearer token
+func NewKMSHandler(p KeyProvider, token string) http.Handler {
+	mux := http.NewServeMux()
+	handle := func(path string, fn func(r *http.Request, req *kmsRequest) (*kmsResponse, error)) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if r.Method != http.MethodPost {
+				writeKMSError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+				writeKMSError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			var req kmsRequest
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxKMSRequest)).Decode(&req); err != nil {
+				var me *http.MaxBytesError
+				if errors.As(err, &me) {
+					writeKMSError(w, http.StatusRequestEntityTooLarge, "request too large")
+					return
+				}
+				writeKMSError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			resp, err := fn(r, &req)
+			if err != nil {
+				writeKMSError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			_ = json.NewEncoder(w).Encode(resp)
+		})
+	}
+	handle("/wrap", func(r *http.Request, req *kmsRequest) (*kmsResponse, error) {
+		id, wrapped, err := p.WrapKey(r.Context(), req.Plaintext)
+		if err != nil {
+			return nil, err
+		}
+		return &kmsResponse{KeyID: id, Ciphertext: wrapped}, nil
+	})
+	handle("/unwrap", func(r *http.Request, req *kmsRequest) (*kmsResponse, error) {
+		key, err := p.UnwrapKey(r.Context(), req.KeyID, req.Ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		return &kmsResponse{Plaintext: key}, nil
+	})
+	return mux
+}
+
+func writeKMSError(w http.ResponseWriter, status int, msg string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(&kmsResponse{Error: msg})
+}
@@ -0,0 +1,52 @@
+package aes
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/happyxhw/pkg/fsutil"
+)
+
+// 默认的输出文件权限, 解密后的明文和密钥文件都不应被其他用户读取
+const defaultPerm os.FileMode = 0600
+
+// out 为空时创建文件中的原始路径, 原始路径由加密方决定, 只允许当前目录下的相对路径
+func createOutput(out, path string, opt Opt) (*fsutil.AtomicFile, error) {
+	if out == "" {
+		if path == "" {
+			return nil, errors.New("empty output path")
+		}
+		var err error
+		if out, err = localPath(".", filepath.ToSlash(filepath.Clean(path))); err != nil {
+			return nil, err
+		}
+		if err = os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return openOutput(out, opt)
+}
+
+// 输出文件通过 fsutil.AtomicFile 原子写入, 失败时不会留下不完整的输出, 也不会破坏已有的文件
+func openOutput(out string, opt Opt) (*fsutil.AtomicFile, error) {
+	perm := opt.Perm
+	if perm == 0 {
+		perm = defaultPerm
+	}
+	return fsutil.CreateAtomic(out, fsutil.AtomicOpt{Perm: perm, NoOverwrite: opt.NoOverwrite})
+}
+
+// 原子写入 data
+func writeOutput(out string, data []byte, opt Opt) error {
+	f, err := openOutput(out, opt)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	return f.Commit()
+}
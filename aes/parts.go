@@ -0,0 +1,456 @@
+package aes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/happyxhw/pkg/fsutil"
+)
+
+/*
+分片加密: 按 fsutil.Split 的分片(偏移 i*SplitSize)并发加密, 每个分片是完整的加密数据(文件头 + 分块密文), 依次拼接.
+所有分片使用同一个内容密钥, 文件头的 Part 字段记录文件 id, 序号, 分片数, 明文偏移和大小, 参与认证.
+各分片文件头长度相同, 除最后一片外加密后的分片大小也相同, SplitEncrypted 得到的分片即加密分片, 可以单独校验.
+*/
+
+// ErrInvalidPart 分片缺失, 重复或不属于同一文件
+var ErrInvalidPart = errors.New("invalid part")
+
+const (
+	fileIDLen   = 16
+	partInfoLen = fileIDLen + 4 + 4 + 8 + 8
+	gcmTagSize  = 16
+)
+
+// PartInfo 分片信息
+type PartInfo struct {
+	FileID []byte // 同一文件的分片相同
+	Index  uint32
+	Count  uint32
+	Offset int64 // 明文偏移
+	Size   int64 // 明文大小
+}
+
+// fileID(16) | index(4) | count(4) | offset(8) | size(8), 均为 BE
+func (p *PartInfo) marshal() ([]byte, error) {
+	if len(p.FileID) != fileIDLen {
+		return nil, ErrInvalidHeader
+	}
+	buf := make([]byte, 0, partInfoLen)
+	buf = append(buf, p.FileID...)
+	buf = binary.BigEndian.AppendUint32(buf, p.Index)
+	buf = binary.BigEndian.AppendUint32(buf, p.Count)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(p.Offset))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(p.Size))
+	return buf, nil
+}
+
+func parsePartInfo(data []byte) (*PartInfo, error) {
+	if len(data) != partInfoLen {
+		return nil, ErrInvalidHeader
+	}
+	p := PartInfo{
+		FileID: data[:fileIDLen],
+		Index:  binary.BigEndian.Uint32(data[fileIDLen:]),
+		Count:  binary.BigEndian.Uint32(data[fileIDLen+4:]),
+		Offset: int64(binary.BigEndian.Uint64(data[fileIDLen+8:])),
+		Size:   int64(binary.BigEndian.Uint64(data[fileIDLen+16:])),
+	}
+	if p.Index >= p.Count || p.Offset < 0 || p.Size < 0 {
+		return nil, ErrInvalidHeader
+	}
+	return &p, nil
+}
+
+// 检查 p 是 first(第一片) 所在文件的第 index 片
+func (p *PartInfo) check(first *PartInfo, index uint32) error {
+	if !bytes.Equal(p.FileID, first.FileID) || p.Index != index || p.Count != first.Count ||
+		p.Offset != int64(index)*first.Size || p.Size > first.Size ||
+		(index+1 < p.Count && p.Size != first.Size) {
+		return ErrInvalidPart
+	}
+	return nil
+}
+
+// 分块模式下 size 字节明文的密文长度
+func chunkedSize(size, chunkSize int64) int64 {
+	chunks := (size + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return size + chunks*gcmTagSize
+}
+
+// 加密 r 中 size 字节的明文, 按 opt.SplitSize 分片
+func encryptParts(ctx context.Context, r io.ReaderAt, size int64, out string, opt Opt, newHeader headerFunc) error {
+	h, key, err := newHeader()
+	if err != nil {
+		return err
+	}
+	if err = initHeader(h, key, opt); err != nil {
+		return err
+	}
+	fileID, err := GenAesKey(fileIDLen)
+	if err != nil {
+		return err
+	}
+	count := (size + opt.SplitSize - 1) / opt.SplitSize
+	if count == 0 {
+		count = 1
+	}
+	if count > math.MaxUint32 {
+		return errors.New("too many parts")
+	}
+	// 文件头长度与 salt 和分片序号无关
+	h.Salt, h.Part = make([]byte, 32), &PartInfo{FileID: fileID, Count: uint32(count)}
+	if _, err = h.MarshalBinary(); err != nil {
+		return err
+	}
+	encPartSize := int64(h.Size()) + chunkedSize(opt.SplitSize, int64(h.ChunkSize))
+
+	outFile, err := openOutput(out, opt)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	progress := fsutil.NewProgress(ctx, size, opt.Progress)
+	err = parallel(int(count), opt.Workers, func(i int) error {
+		part := PartInfo{FileID: fileID, Index: uint32(i), Count: uint32(count), Offset: int64(i) * opt.SplitSize}
+		part.Size = size - part.Offset
+		if part.Size > opt.SplitSize {
+			part.Size = opt.SplitSize
+		}
+		ph := *h
+		ph.Part = &part
+		w, err := newChunkedWriter(&offsetWriter{w: outFile, off: int64(i) * encPartSize}, &ph, key)
+		if err != nil {
+			return err
+		}
+		if _, err = io.CopyBuffer(w, progress.Reader(io.NewSectionReader(r, part.Offset, part.Size)), make([]byte, bufLen)); err != nil {
+			return err
+		}
+		return w.Close()
+	})
+	if err != nil {
+		return err
+	}
+	return outFile.Commit()
+}
+
+// 并发解密分片文件, h 为第一片的文件头
+func decryptParts(ctx context.Context, inFile *os.File, h *Header, out string, keys *Keys, opt Opt) (string, error) {
+	fi, err := inFile.Stat()
+	if err != nil {
+		return "", err
+	}
+	key, path, err := keys.open(ctx, h)
+	if err != nil {
+		return "", err
+	}
+	p, err := newPartsReaderAt(inFile, fi.Size(), h, key)
+	if err != nil {
+		return "", err
+	}
+
+	outFile, err := createOutput(out, path, opt)
+	if err != nil {
+		return "", err
+	}
+	defer outFile.Close()
+
+	// 并发解密时按明文统计进度
+	progress := fsutil.NewProgress(ctx, p.size, opt.Progress)
+	err = parallel(int(h.Part.Count), opt.Workers, func(i int) error {
+		c, err := p.part(uint32(i))
+		if err != nil {
+			return err
+		}
+		w := &offsetWriter{w: outFile, off: int64(i) * h.Part.Size}
+		_, err = io.CopyBuffer(w, progress.Reader(io.NewSectionReader(c, 0, c.size)), make([]byte, bufLen))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return path, outFile.Commit()
+}
+
+// SplitEncrypted 按加密分片的边界切分 Opt.SplitSize 加密的文件, 各分片可以单独上传和校验
+func SplitEncrypted(in string) (*fsutil.File, error) {
+	f, err := os.Open(in)
+	if err != nil {
+		return nil, err
+	}
+	h, err := ReadHeader(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if h.Part == nil || h.Part.Index != 0 {
+		return nil, ErrInvalidPart
+	}
+	return fsutil.Split(in, int64(h.Size())+chunkedSize(h.Part.Size, int64(h.ChunkSize)))
+}
+
+// DecryptPart 解密一个加密分片到 w, w 为 io.Discard 时只做校验
+func DecryptPart(r io.Reader, w io.Writer, keys *Keys) (*PartInfo, error) {
+	h, err := ReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if h.Part == nil || h.Cipher != CipherAES256GCMChunk {
+		return nil, ErrInvalidPart
+	}
+	key, err := keys.contentKey(context.Background(), h)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newChunkAEAD(key, h)
+	if err != nil {
+		return nil, err
+	}
+	cr := newChunkReader(io.LimitReader(r, chunkedSize(h.Part.Size, int64(h.ChunkSize))), aead, int(h.ChunkSize))
+	n, err := io.CopyBuffer(w, cr, make([]byte, bufLen))
+	if err != nil {
+		return nil, err
+	}
+	if n != h.Part.Size {
+		return nil, ErrInvalidPart
+	}
+	// 不能有多余数据
+	if m, _ := r.Read(make([]byte, 1)); m > 0 {
+		return nil, ErrInvalidPart
+	}
+	return h.Part, nil
+}
+
+// VerifyPart 校验一个加密分片
+func VerifyPart(r io.Reader, keys *Keys) (*PartInfo, error) {
+	return DecryptPart(r, io.Discard, keys)
+}
+
+// partsReader 顺序解密分片文件
+type partsReader struct {
+	r     *bufio.Reader
+	key   []byte
+	first *PartInfo
+	h     *Header
+	cur   io.Reader
+}
+
+func newPartsReader(r *bufio.Reader, h *Header, key []byte) (*partsReader, error) {
+	if h.Part.Index != 0 {
+		return nil, ErrInvalidPart
+	}
+	p := partsReader{r: r, key: key, first: h.Part}
+	return &p, p.open(h)
+}
+
+func (p *partsReader) open(h *Header) error {
+	aead, err := newChunkAEAD(p.key, h)
+	if err != nil {
+		return err
+	}
+	p.h = h
+	p.cur = newChunkReader(io.LimitReader(p.r, chunkedSize(h.Part.Size, int64(h.ChunkSize))), aead, int(h.ChunkSize))
+	return nil
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for {
+		n, err := p.cur.Read(b)
+		if !errors.Is(err, io.EOF) {
+			return n, err
+		}
+		next := p.h.Part.Index + 1
+		if next == p.h.Part.Count {
+			if _, err = p.r.Peek(1); err == nil {
+				return 0, ErrInvalidPart
+			}
+			return 0, io.EOF
+		}
+		h, err := ReadHeader(p.r)
+		if errors.Is(err, ErrNoHeader) {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+		if h.Part == nil {
+			return 0, ErrInvalidPart
+		}
+		if err = h.Part.check(p.first, next); err != nil {
+			return 0, err
+		}
+		if err = p.open(h); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// partsReaderAt 分片文件的随机读取, 按需读取各分片的文件头
+type partsReaderAt struct {
+	r        io.ReaderAt
+	key      []byte
+	first    *Header
+	encSize  int64 // 加密文件大小
+	partSize int64 // 除最后一片外加密分片的大小
+	size     int64 // 明文大小
+
+	mu    sync.Mutex
+	parts map[uint32]*chunkReaderAt
+}
+
+func newPartsReaderAt(r io.ReaderAt, encSize int64, h *Header, key []byte) (*partsReaderAt, error) {
+	if h.Part.Index != 0 || h.Cipher != CipherAES256GCMChunk {
+		return nil, ErrInvalidPart
+	}
+	p := partsReaderAt{
+		r:        r,
+		key:      key,
+		first:    h,
+		encSize:  encSize,
+		partSize: int64(h.Size()) + chunkedSize(h.Part.Size, int64(h.ChunkSize)),
+		parts:    make(map[uint32]*chunkReaderAt),
+	}
+	// 读取最后一片得到明文大小, 同时检查分片数
+	last, err := p.part(h.Part.Count - 1)
+	if err != nil {
+		return nil, err
+	}
+	p.size = int64(h.Part.Count-1)*h.Part.Size + last.size
+	return &p, nil
+}
+
+func (p *partsReaderAt) part(i uint32) (*chunkReaderAt, error) {
+	p.mu.Lock()
+	c, ok := p.parts[i]
+	p.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	off, end := int64(i)*p.partSize, int64(i+1)*p.partSize
+	if i+1 == p.first.Part.Count {
+		end = p.encSize
+	}
+	if end > p.encSize || off >= end {
+		return nil, ErrInvalidPart
+	}
+	h := p.first
+	if i > 0 {
+		var err error
+		if h, err = ReadHeader(io.NewSectionReader(p.r, off, end-off)); err != nil {
+			return nil, err
+		}
+		if h.Part == nil || h.Size() != p.first.Size() {
+			return nil, ErrInvalidPart
+		}
+		if err = h.Part.check(p.first.Part, i); err != nil {
+			return nil, err
+		}
+	}
+	aead, err := newChunkAEAD(p.key, h)
+	if err != nil {
+		return nil, err
+	}
+	base := off + int64(h.Size())
+	c, err = newChunkReaderAt(p.r, base, end-base, aead, int64(h.ChunkSize))
+	if err != nil {
+		return nil, err
+	}
+	if c.size != h.Part.Size {
+		return nil, ErrInvalidPart
+	}
+
+	p.mu.Lock()
+	p.parts[i] = c
+	p.mu.Unlock()
+	return c, nil
+}
+
+func (p *partsReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	split := p.first.Part.Size
+	var n int
+	for n < len(b) {
+		pos := off + int64(n)
+		if pos >= p.size {
+			return n, io.EOF
+		}
+		i := uint32(pos / split)
+		c, err := p.part(i)
+		if err != nil {
+			return n, err
+		}
+		m, err := c.ReadAt(b[n:], pos-int64(i)*split)
+		n += m
+		if err != nil && !errors.Is(err, io.EOF) {
+			return n, err
+		}
+		if m == 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+	}
+	return n, nil
+}
+
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	return n, err
+}
+
+// 使用 workers 个 goroutine 执行 fn(0) 到 fn(n-1), 返回第一个错误.
+// workers 不大于 0 时使用 runtime.NumCPU()
+func parallel(n, workers int, fn func(i int) error) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > n {
+		workers = n
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		next     int64 = -1
+		failed   int32
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&failed) == 0 {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= n {
+					return
+				}
+				if err := fn(i); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					atomic.StoreInt32(&failed, 1)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
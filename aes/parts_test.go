@@ -0,0 +1,101 @@
+package aes
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/happyxhw/pkg/fsutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptFileParts(t *testing.T) {
+	dir := t.TempDir()
+	in, out, dec := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"), filepath.Join(dir, "dec.txt")
+	keyKey, err := GenAesKey(32)
+	require.NoError(t, err)
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keys := &Keys{KeyKey: keyKey, PrivateKey: pk}
+	const splitSize = 200 * 1024
+
+	for _, size := range []int{0, 100, splitSize, 3*splitSize + 1000} {
+		data := make([]byte, size)
+		_, err = rand.Read(data)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(in, data, 0600))
+
+		require.NoError(t, EncryptFileWithRSA(in, out, keyKey, &pk.PublicKey, Opt{Path: in, SplitSize: splitSize, Workers: 2}))
+		path, err := DecryptAny(out, dec, keys)
+		require.NoError(t, err)
+		require.Equal(t, in, path)
+		requireFile(t, dec, data)
+		_, err = DecryptAny(out, dec, keys, Opt{Workers: 1})
+		require.NoError(t, err)
+		requireFile(t, dec, data)
+
+		// 顺序解密
+		f, err := os.Open(out)
+		require.NoError(t, err)
+		r, err := NewDecryptReaderAny(f, keys)
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		require.Equal(t, data, got)
+
+		// 跨分片随机读取
+		rr, err := OpenRangeReader(out, keys)
+		require.NoError(t, err)
+		require.Equal(t, int64(size), rr.Size())
+		got, err = rr.DecryptRange(splitSize-10, splitSize+20)
+		require.NoError(t, err)
+		require.Equal(t, data[min64(splitSize-10, int64(size)):min64(2*splitSize+10, int64(size))], got)
+		require.NoError(t, rr.Close())
+
+		// 加密分片与明文分片对齐, 并且可以单独校验
+		plain, err := fsutil.Split(in, splitSize)
+		require.NoError(t, err)
+		encParts, err := SplitEncrypted(out)
+		require.NoError(t, err)
+		require.Len(t, encParts.Parts, len(plain.Parts))
+		enc, err := os.ReadFile(out)
+		require.NoError(t, err)
+		for i, p := range encParts.Parts {
+			part, err := VerifyPart(bytes.NewReader(enc[p.Offset:p.Offset+p.Size]), keys)
+			require.NoError(t, err)
+			require.Equal(t, uint32(i), part.Index)
+			require.Equal(t, plain.Parts[i].Offset, part.Offset)
+			require.Equal(t, plain.Parts[i].Size, part.Size)
+		}
+	}
+
+	// 重排或缺少分片
+	encParts, err := SplitEncrypted(out)
+	require.NoError(t, err)
+	enc, err := os.ReadFile(out)
+	require.NoError(t, err)
+	part := func(i int) []byte {
+		p := encParts.Parts[i]
+		return enc[p.Offset : p.Offset+p.Size]
+	}
+	for _, parts := range [][]int{{0, 2, 1, 3}, {0, 1, 3}, {0, 1, 2}, {1, 2, 3}} {
+		var buf []byte
+		for _, i := range parts {
+			buf = append(buf, part(i)...)
+		}
+		require.NoError(t, os.WriteFile(out, buf, 0600))
+		_, err = DecryptAny(out, dec, keys)
+		require.Error(t, err, parts)
+
+		r, err := NewDecryptReaderAny(bytes.NewReader(buf), keys)
+		if err == nil {
+			_, err = io.ReadAll(r)
+		}
+		require.Error(t, err, parts)
+	}
+}
@@ -0,0 +1,153 @@
+package aes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// 口令加密的 PKCS#8(RFC 5958 EncryptedPrivateKeyInfo), 使用 PBES2(RFC 8018):
+// PBKDF2-HMAC-SHA256 + AES-256-CBC, 与 openssl pkcs8 -topk8 -v2 aes-256-cbc 兼容
+
+// ErrIncorrectPassphrase 口令错误或数据损坏
+var ErrIncorrectPassphrase = errors.New("incorrect passphrase")
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+const pkcs8Iter = 600000
+
+type encryptedPrivateKeyInfo struct {
+	Algo          pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// 加密 PKCS#8 DER
+func encryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	salt, err := GenAesKey(kdfSaltLen)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := GenAesKey(aes.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+	key := pbkdf2.Key(passphrase, salt, pkcs8Iter, 32, sha256.New)
+	encrypted, err := EncryptCBC(append([]byte{}, der...), key, iv)
+	if err != nil {
+		return nil, err
+	}
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pkcs8Iter,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algo:          pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: encrypted,
+	})
+}
+
+// 解密 EncryptedPrivateKeyInfo, 返回 PKCS#8 DER
+func decryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algo.Algorithm.Equal(oidPBES2) {
+		return nil, errors.New("unsupported pkcs8 encryption")
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algo.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, errors.New("unsupported pkcs8 kdf")
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, err
+	}
+	if kdf.IterationCount <= 0 || kdf.IterationCount > maxPBKDF2Iter {
+		return nil, ErrInvalidKDFParams
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdf.PRF.Algorithm) == 0 || kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	default:
+		return nil, errors.New("unsupported pkcs8 prf")
+	}
+	var keyLen int
+	switch {
+	case params.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLen = 32
+	case params.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLen = 16
+	default:
+		return nil, errors.New("unsupported pkcs8 cipher")
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize || len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, ErrIncorrectPassphrase
+	}
+
+	key := pbkdf2.Key(passphrase, kdf.Salt, kdf.IterationCount, keyLen, prf)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, info.EncryptedData)
+	plain, ok := unpadPKCS7(plain, aes.BlockSize)
+	if !ok {
+		return nil, ErrIncorrectPassphrase
+	}
+	return plain, nil
+}
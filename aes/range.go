@@ -0,0 +1,358 @@
+package aes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+/*
+随机读取, 不需要从头解密:
+	分块 AES-GCM: 只读取并认证覆盖范围的块, 块 i 位于 文件头 + i*(ChunkSize+16)
+	CTR(包括旧格式): 计数器 = iv + offset/16
+	分片文件: 按需读取各分片的文件头
+分块模式只认证读到的块, 截断在读到最后一块时才能发现
+*/
+
+var errNegativeOffset = errors.New("negative offset")
+
+// RangeReader 按偏移解密, 实现 io.ReaderAt 和 io.ReadSeeker, ReadAt 可以并发调用
+type RangeReader struct {
+	ra     io.ReaderAt // 明文
+	size   int64
+	header *Header
+	path   string
+	offset int64
+	closer io.Closer
+}
+
+// NewRangeReader 解密 r 中 size 字节的密文, 支持所有文件头格式和旧格式.
+// 旧格式按 keys 选择解密方式, 同 NewDecryptReaderAny
+func NewRangeReader(r io.ReaderAt, size int64, keys *Keys) (*RangeReader, error) {
+	return NewRangeReaderContext(context.Background(), r, size, keys)
+}
+
+// NewRangeReaderContext 同 NewRangeReader, ctx 用于 Keys.Provider 解开内容密钥
+func NewRangeReaderContext(ctx context.Context, r io.ReaderAt, size int64, keys *Keys) (*RangeReader, error) {
+	prefix := make([]byte, len(magic))
+	n, err := r.ReadAt(prefix, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if !bytes.Equal(prefix[:n], magic) {
+		return newLegacyRangeReader(r, size, keys)
+	}
+
+	h, err := ReadHeader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	if h.Codec != CodecNone {
+		return nil, ErrCompressed
+	}
+	key, path, err := keys.open(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	base := int64(h.Size())
+	rr := RangeReader{header: h, path: path, size: size - base}
+	switch h.Cipher {
+	case CipherAES256GCMChunk:
+		if h.Part != nil {
+			p, err := newPartsReaderAt(r, size, h, key)
+			if err != nil {
+				return nil, err
+			}
+			rr.ra, rr.size = p, p.size
+			break
+		}
+		aead, err := newChunkAEAD(key, h)
+		if err != nil {
+			return nil, err
+		}
+		c, err := newChunkReaderAt(r, base, size-base, aead, int64(h.ChunkSize))
+		if err != nil {
+			return nil, err
+		}
+		rr.ra, rr.size = c, c.size
+	case CipherAES256CTR:
+		block, iv, err := newCTRBlock(h, key)
+		if err != nil {
+			return nil, err
+		}
+		rr.ra = &ctrReaderAt{r: r, base: base, block: block, iv: iv}
+	default:
+		return nil, ErrUnsupported
+	}
+	return &rr, nil
+}
+
+func newLegacyRangeReader(r io.ReaderAt, size int64, keys *Keys) (*RangeReader, error) {
+	if keys.PrivateKey != nil {
+		br := bufio.NewReaderSize(io.NewSectionReader(r, 0, size), legacyBufLen)
+		block, iv, path, base, err := readLegacyRSAHeader(br, keys)
+		if err != nil {
+			return nil, err
+		}
+		return &RangeReader{ra: &ctrReaderAt{r: r, base: base, block: block, iv: iv}, size: size - base, path: path}, nil
+	}
+	if keys.Key == nil {
+		return nil, ErrMissingKey
+	}
+
+	block, err := aes.NewCipher(keys.Key)
+	if err != nil {
+		return nil, err
+	}
+	if keys.FixedIV {
+		return &RangeReader{ra: &ctrReaderAt{r: r, block: block, iv: keys.Key[:block.BlockSize()]}, size: size}, nil
+	}
+	// iv 在末尾
+	size -= int64(block.BlockSize())
+	if size < 0 {
+		return nil, errors.New("encrypted too short")
+	}
+	iv := make([]byte, block.BlockSize())
+	if _, err = r.ReadAt(iv, size); err != nil {
+		return nil, err
+	}
+	return &RangeReader{ra: &ctrReaderAt{r: r, block: block, iv: iv}, size: size}, nil
+}
+
+// OpenRangeReader 打开加密文件, 使用完需要 Close
+func OpenRangeReader(in string, keys *Keys) (*RangeReader, error) {
+	f, err := os.Open(in)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	r, err := NewRangeReader(f, fi.Size(), keys)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	r.closer = f
+	return r, nil
+}
+
+// DecryptRange 解密文件中 [offset, offset+length) 的明文, 超出文件的部分被忽略
+func DecryptRange(in string, offset, length int64, keys *Keys) ([]byte, error) {
+	r, err := OpenRangeReader(in, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return r.DecryptRange(offset, length)
+}
+
+// DecryptRange 解密 [offset, offset+length) 的明文, 超出文件的部分被忽略
+func (r *RangeReader) DecryptRange(offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, errNegativeOffset
+	}
+	if offset >= r.size {
+		return []byte{}, nil
+	}
+	if length > r.size-offset {
+		length = r.size - offset
+	}
+	buf := make([]byte, length)
+	n, err := r.ReadAt(buf, offset)
+	if err != nil && !(errors.Is(err, io.EOF) && n == len(buf)) {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (r *RangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	want := len(p)
+	if int64(want) > r.size-off {
+		p = p[:r.size-off]
+	}
+	n, err := r.ra.ReadAt(p, off)
+	if err == nil && n < want {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r *RangeReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if errors.Is(err, io.EOF) && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *RangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errNegativeOffset
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Size 明文大小
+func (r *RangeReader) Size() int64 {
+	return r.size
+}
+
+// Header 文件头, 旧格式时为 nil
+func (r *RangeReader) Header() *Header {
+	return r.header
+}
+
+// Path 文件头中的原始路径, 没有时为空
+func (r *RangeReader) Path() string {
+	return r.path
+}
+
+// Close 关闭 OpenRangeReader 打开的文件
+func (r *RangeReader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// chunkReaderAt 按块解密, 缓存最近一块用于顺序读取
+type chunkReaderAt struct {
+	r         io.ReaderAt
+	base      int64
+	encSize   int64
+	size      int64
+	aead      cipher.AEAD
+	chunkSize int64
+	chunks    int64
+
+	mu    sync.Mutex
+	seq   int64
+	plain []byte
+}
+
+func newChunkReaderAt(r io.ReaderAt, base, encSize int64, aead cipher.AEAD, chunkSize int64) (*chunkReaderAt, error) {
+	overhead := int64(aead.Overhead())
+	encChunk := chunkSize + overhead
+	chunks := (encSize + encChunk - 1) / encChunk
+	// 每块至少有 tag
+	if chunks == 0 || encSize-(chunks-1)*encChunk < overhead {
+		return nil, ErrAuth
+	}
+	return &chunkReaderAt{
+		r:         r,
+		base:      base,
+		encSize:   encSize,
+		size:      encSize - chunks*overhead,
+		aead:      aead,
+		chunkSize: chunkSize,
+		chunks:    chunks,
+		seq:       -1,
+	}, nil
+}
+
+func (c *chunkReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	for n < len(p) {
+		seq := (off + int64(n)) / c.chunkSize
+		if seq >= c.chunks {
+			return n, io.EOF
+		}
+		plain, err := c.chunk(seq)
+		if err != nil {
+			return n, err
+		}
+		i := off + int64(n) - seq*c.chunkSize
+		if i >= int64(len(plain)) {
+			return n, io.EOF
+		}
+		n += copy(p[n:], plain[i:])
+	}
+	return n, nil
+}
+
+func (c *chunkReaderAt) chunk(seq int64) ([]byte, error) {
+	c.mu.Lock()
+	if c.seq == seq {
+		plain := c.plain
+		c.mu.Unlock()
+		return plain, nil
+	}
+	c.mu.Unlock()
+
+	encChunk := c.chunkSize + int64(c.aead.Overhead())
+	off := seq * encChunk
+	buf := make([]byte, encChunk)
+	if c.encSize-off < encChunk {
+		buf = buf[:c.encSize-off]
+	}
+	if n, err := c.r.ReadAt(buf, c.base+off); n < len(buf) {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	nonce := chunkNonce(make([]byte, c.aead.NonceSize()), uint64(seq), seq == c.chunks-1)
+	plain, err := c.aead.Open(buf[:0], nonce, buf, nil)
+	if err != nil {
+		return nil, ErrAuth
+	}
+
+	c.mu.Lock()
+	c.seq, c.plain = seq, plain
+	c.mu.Unlock()
+	return plain, nil
+}
+
+// ctrReaderAt CTR 模式可以直接定位到任意字节
+type ctrReaderAt struct {
+	r     io.ReaderAt
+	base  int64
+	block cipher.Block
+	iv    []byte
+}
+
+func (c *ctrReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, c.base+off)
+	blockSize := int64(c.block.BlockSize())
+
+	// iv 作为大端整数加上块序号
+	ctr := make([]byte, len(c.iv))
+	copy(ctr, c.iv)
+	carry := uint64(off / blockSize)
+	for i := len(ctr) - 1; i >= 0 && carry > 0; i-- {
+		carry += uint64(ctr[i])
+		ctr[i] = byte(carry)
+		carry >>= 8
+	}
+	stream := cipher.NewCTR(c.block, ctr)
+	skip := make([]byte, off%blockSize)
+	stream.XORKeyStream(skip, skip)
+	stream.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
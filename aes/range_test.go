@@ -0,0 +1,111 @@
+package aes
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeReader(t *testing.T) {
+	data := make([]byte, 3*DefaultChunkSize+100)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+	keyKey, err := GenAesKey(32)
+	require.NoError(t, err)
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	encrypt := func(data []byte, newWriter WriterFunc) []byte {
+		var buf bytes.Buffer
+		w, err := newWriter(&buf)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+	// 文件头 CTR 格式
+	ctrHeader := func(data []byte) []byte {
+		iv, err := GenAesKey(aes.BlockSize)
+		require.NoError(t, err)
+		h := Header{Version: Version1, Cipher: CipherAES256CTR, KDF: KDFRaw, IV: iv}
+		var buf bytes.Buffer
+		_, err = h.WriteTo(&buf)
+		require.NoError(t, err)
+		block, err := aes.NewCipher(key)
+		require.NoError(t, err)
+		w := cipher.StreamWriter{S: cipher.NewCTR(block, iv), W: &buf}
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+
+	for _, data := range [][]byte{data, data[:2*DefaultChunkSize], data[:10], {}} {
+		cases := []struct {
+			enc  []byte
+			keys *Keys
+		}{
+			{encrypt(data, func(w io.Writer) (io.WriteCloser, error) { return NewEncryptWriter(w, key) }), &Keys{Key: key}},
+			{encrypt(data, func(w io.Writer) (io.WriteCloser, error) {
+				return NewEncryptWriterWithRSA(w, keyKey, &pk.PublicKey, Opt{Path: "a.txt"})
+			}), &Keys{KeyKey: keyKey, PrivateKey: pk}},
+			{ctrHeader(data), &Keys{Key: key}},
+			{legacyEncrypt(t, data, key, true), &Keys{Key: key, FixedIV: true}},
+			{legacyEncrypt(t, data, key, false), &Keys{Key: key}},
+			{legacyEncryptRSA(t, data, "a.txt", keyKey, &pk.PublicKey, true), &Keys{KeyKey: keyKey, PrivateKey: pk}},
+		}
+		for i, c := range cases {
+			r, err := NewRangeReader(bytes.NewReader(c.enc), int64(len(c.enc)), c.keys)
+			require.NoError(t, err, i)
+			require.Equal(t, int64(len(data)), r.Size(), i)
+
+			for _, rg := range [][2]int64{{0, 10}, {5, DefaultChunkSize}, {DefaultChunkSize - 1, 2}, {2*DefaultChunkSize + 3, 1 << 20}, {int64(len(data)), 10}} {
+				got, err := r.DecryptRange(rg[0], rg[1])
+				require.NoError(t, err, i)
+				start, end := min64(rg[0], int64(len(data))), min64(rg[0]+rg[1], int64(len(data)))
+				require.Equal(t, data[start:end], got, i)
+			}
+
+			_, err = r.Seek(-int64(len(data)/2), io.SeekEnd)
+			require.NoError(t, err)
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, data[len(data)-len(data)/2:], got, i)
+		}
+	}
+
+	// 篡改的块无法通过认证
+	enc := encrypt(data, func(w io.Writer) (io.WriteCloser, error) { return NewEncryptWriter(w, key) })
+	enc[len(enc)-50] ^= 1
+	r, err := NewRangeReader(bytes.NewReader(enc), int64(len(enc)), &Keys{Key: key})
+	require.NoError(t, err)
+	_, err = r.DecryptRange(0, 10)
+	require.NoError(t, err)
+	_, err = r.DecryptRange(int64(len(data))-10, 10)
+	require.ErrorIs(t, err, ErrAuth)
+
+	// 截断到块边界
+	enc = encrypt(data, func(w io.Writer) (io.WriteCloser, error) { return NewEncryptWriter(w, key) })
+	h, err := ReadHeader(bytes.NewReader(enc))
+	require.NoError(t, err)
+	enc = enc[:h.Size()+2*(DefaultChunkSize+16)]
+	r, err = NewRangeReader(bytes.NewReader(enc), int64(len(enc)), &Keys{Key: key})
+	require.NoError(t, err)
+	_, err = r.DecryptRange(DefaultChunkSize, 10)
+	require.ErrorIs(t, err, ErrAuth)
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,359 @@
+package aes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+/*
+多接收方: 同一个内容密钥分别为每个接收方加密, 存于文件头的 Stanza 中.
+Stanza 不参与密文认证, 增删接收方只需重写文件头, 分片文件重写每个分片的文件头.
+*/
+
+var (
+	// ErrNoIdentity 没有可以解密的私钥
+	ErrNoIdentity = errors.New("no matching identity")
+	// ErrNoRecipient 至少需要一个接收方
+	ErrNoRecipient = errors.New("no recipient")
+)
+
+const fingerprintLen = 8
+
+// StanzaType 接收方类型
+type StanzaType byte
+
+const (
+	// StanzaRSAOAEP RSA-OAEP(SHA-256) 加密内容密钥
+	StanzaRSAOAEP StanzaType = 1
+	// StanzaX25519 X25519 协商的密钥加密内容密钥
+	StanzaX25519 StanzaType = 2
+)
+
+// Stanza 文件头中一个接收方的记录
+type Stanza struct {
+	Type        StanzaType
+	Fingerprint []byte // 接收方公钥指纹
+	Args        []byte // 如 X25519 的临时公钥
+	Body        []byte // 被加密的内容密钥
+}
+
+// Recipient 接收方公钥
+type Recipient interface {
+	Fingerprint() []byte
+	Wrap(key []byte) (*Stanza, error)
+}
+
+// Identity 接收方私钥, Stanza 不匹配时返回 ErrNoIdentity
+type Identity interface {
+	Unwrap(s *Stanza) ([]byte, error)
+}
+
+// RSARecipient RSA 接收方
+type RSARecipient struct {
+	PublicKey *rsa.PublicKey
+}
+
+// Fingerprint 公钥 PKIX 编码的 SHA-256 前 8 字节
+func (r *RSARecipient) Fingerprint() []byte {
+	der, err := x509.MarshalPKIXPublicKey(r.PublicKey)
+	if err != nil {
+		return nil
+	}
+	return fingerprint(der)
+}
+
+func (r *RSARecipient) Wrap(key []byte) (*Stanza, error) {
+	body, err := RsaEncryptOAEP(key, r.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Stanza{Type: StanzaRSAOAEP, Fingerprint: r.Fingerprint(), Body: body}, nil
+}
+
+// RSAIdentity RSA 私钥
+type RSAIdentity struct {
+	PrivateKey *rsa.PrivateKey
+}
+
+func (i *RSAIdentity) Unwrap(s *Stanza) ([]byte, error) {
+	r := RSARecipient{PublicKey: &i.PrivateKey.PublicKey}
+	if s.Type != StanzaRSAOAEP || !bytes.Equal(s.Fingerprint, r.Fingerprint()) {
+		return nil, ErrNoIdentity
+	}
+	return RsaDecryptOAEP(s.Body, i.PrivateKey)
+}
+
+// X25519Recipient X25519 接收方
+type X25519Recipient struct {
+	PublicKey X25519PublicKey
+}
+
+// Fingerprint 公钥的 SHA-256 前 8 字节
+func (r *X25519Recipient) Fingerprint() []byte {
+	return fingerprint(r.PublicKey)
+}
+
+func (r *X25519Recipient) Wrap(key []byte) (*Stanza, error) {
+	ephemeral, body, err := wrapX25519(key, r.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Stanza{Type: StanzaX25519, Fingerprint: r.Fingerprint(), Args: ephemeral, Body: body}, nil
+}
+
+// X25519Identity X25519 私钥
+type X25519Identity struct {
+	PrivateKey X25519PrivateKey
+}
+
+func (i *X25519Identity) Unwrap(s *Stanza) ([]byte, error) {
+	pub, err := i.PrivateKey.Public()
+	if err != nil {
+		return nil, err
+	}
+	if s.Type != StanzaX25519 || !bytes.Equal(s.Fingerprint, fingerprint(pub)) {
+		return nil, ErrNoIdentity
+	}
+	return unwrapX25519(s.Body, s.Args, i.PrivateKey)
+}
+
+func fingerprint(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:fingerprintLen]
+}
+
+// NewEncryptWriterToRecipients 随机生成 key 加密数据, key 分别为每个接收方加密后写入文件头
+func NewEncryptWriterToRecipients(w io.Writer, recipients []Recipient, opts ...Opt) (io.WriteCloser, error) {
+	h, key, err := newRecipientsHeader(recipients)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptWriter(w, h, key, getOpt(opts...))
+}
+
+// NewDecryptReaderWithIdentities 使用任意一个匹配的私钥解密 NewEncryptWriterToRecipients 的输出
+func NewDecryptReaderWithIdentities(r io.Reader, identities ...Identity) (*Reader, error) {
+	return NewDecryptReaderAny(r, &Keys{Identities: identities})
+}
+
+// EncryptFileToRecipients 加密文件, 任意一个接收方的私钥都可以解密
+func EncryptFileToRecipients(in, out string, recipients []Recipient, opts ...Opt) error {
+	return EncryptFileToRecipientsContext(context.Background(), in, out, recipients, opts...)
+}
+
+func EncryptFileToRecipientsContext(ctx context.Context, in, out string, recipients []Recipient, opts ...Opt) error {
+	return encryptFile(ctx, in, out, getOpt(opts...), func() (*Header, []byte, error) {
+		return newRecipientsHeader(recipients)
+	})
+}
+
+func DecryptFileWithIdentities(in, out string, identities ...Identity) error {
+	_, err := DecryptAny(in, out, &Keys{Identities: identities})
+	return err
+}
+
+// AddRecipients 使用 identity 解出内容密钥后增加接收方, 只重写文件头, out 不能与 in 相同
+func AddRecipients(in, out string, identity Identity, recipients ...Recipient) error {
+	return rewriteHeader(in, out, func(h *Header) error {
+		key, err := unwrapStanzas(h.Recipients, []Identity{identity})
+		if err != nil {
+			return err
+		}
+		stanzas, err := wrapStanzas(key, recipients)
+		if err != nil {
+			return err
+		}
+		h.Recipients = append(h.Recipients, stanzas...)
+		return nil
+	})
+}
+
+// RemoveRecipients 删除接收方, 只重写文件头, out 不能与 in 相同. 不允许删除所有接收方
+func RemoveRecipients(in, out string, recipients ...Recipient) error {
+	return rewriteHeader(in, out, func(h *Header) error {
+		stanzas := h.Recipients[:0:0]
+		for _, s := range h.Recipients {
+			keep := true
+			for _, r := range recipients {
+				if bytes.Equal(s.Fingerprint, r.Fingerprint()) {
+					keep = false
+					break
+				}
+			}
+			if keep {
+				stanzas = append(stanzas, s)
+			}
+		}
+		if len(stanzas) == 0 {
+			return ErrNoRecipient
+		}
+		h.Recipients = stanzas
+		return nil
+	})
+}
+
+// 修改多接收方文件的文件头, 密文原样复制.
+// 分片文件的每个分片都有文件头, 各分片使用修改后的同一组 Stanza, 文件头长度仍然相同
+func rewriteHeader(in, out string, fn func(h *Header) error) error {
+	if in == out {
+		return ErrSameFile
+	}
+	inFile, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+
+	r := bufio.NewReaderSize(inFile, bufLen)
+	h, err := ReadHeader(r)
+	if err != nil {
+		return err
+	}
+	if h.KDF != KDFRecipients {
+		return ErrUnsupported
+	}
+	if err = fn(h); err != nil {
+		return err
+	}
+
+	outFile, err := openOutput(out, Opt{})
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	if h.Part == nil || h.Part.Count == 1 {
+		if _, err = h.WriteTo(outFile); err != nil {
+			return err
+		}
+		if _, err = io.CopyBuffer(outFile, r, make([]byte, bufLen)); err != nil {
+			return err
+		}
+		return outFile.Commit()
+	}
+	if err = rewritePartHeaders(outFile, r, h); err != nil {
+		return err
+	}
+	return outFile.Commit()
+}
+
+// 依次写入各分片修改后的文件头和原样的密文, first 为已经修改的第一片文件头
+func rewritePartHeaders(w io.Writer, r *bufio.Reader, first *Header) error {
+	if first.Part.Index != 0 || first.Cipher != CipherAES256GCMChunk {
+		return ErrInvalidPart
+	}
+	h := first
+	for i := uint32(0); ; i++ {
+		if _, err := h.WriteTo(w); err != nil {
+			return err
+		}
+		n := chunkedSize(h.Part.Size, int64(h.ChunkSize))
+		if _, err := io.CopyN(w, r, n); errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+		if i+1 == first.Part.Count {
+			break
+		}
+		next, err := ReadHeader(r)
+		if errors.Is(err, ErrNoHeader) {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+		if next.KDF != KDFRecipients || next.Part == nil {
+			return ErrInvalidPart
+		}
+		if err = next.Part.check(first.Part, i+1); err != nil {
+			return err
+		}
+		next.Recipients = first.Recipients
+		h = next
+	}
+	// 不能有多余数据
+	if _, err := r.Peek(1); err == nil {
+		return ErrInvalidPart
+	}
+	return nil
+}
+
+// 随机生成 key, 分别为每个接收方加密后存于文件头
+func newRecipientsHeader(recipients []Recipient) (*Header, []byte, error) {
+	key, err := GenAesKey(32)
+	if err != nil {
+		return nil, nil, err
+	}
+	stanzas, err := wrapStanzas(key, recipients)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(stanzas) == 0 {
+		return nil, nil, ErrNoRecipient
+	}
+	return &Header{KDF: KDFRecipients, Recipients: stanzas}, key, nil
+}
+
+func wrapStanzas(key []byte, recipients []Recipient) ([]*Stanza, error) {
+	stanzas := make([]*Stanza, 0, len(recipients))
+	for _, r := range recipients {
+		s, err := r.Wrap(key)
+		if err != nil {
+			return nil, err
+		}
+		stanzas = append(stanzas, s)
+	}
+	return stanzas, nil
+}
+
+// Stanza 不参与认证, 解开失败时继续尝试其他 Stanza 和私钥, 都失败时返回最后一个错误
+func unwrapStanzas(stanzas []*Stanza, identities []Identity) ([]byte, error) {
+	lastErr := ErrNoIdentity
+	for _, s := range stanzas {
+		for _, i := range identities {
+			key, err := i.Unwrap(s)
+			if err == nil {
+				return key, nil
+			}
+			if !errors.Is(err, ErrNoIdentity) {
+				lastErr = err
+			}
+		}
+	}
+	return nil, lastErr
+}
+
+// type(1) | len(fingerprint)(1) | fingerprint | len(args)(2, BE) | args | body
+func (s *Stanza) marshal() []byte {
+	buf := make([]byte, 0, 4+len(s.Fingerprint)+len(s.Args)+len(s.Body))
+	buf = append(buf, byte(s.Type), byte(len(s.Fingerprint)))
+	buf = append(buf, s.Fingerprint...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s.Args)))
+	buf = append(buf, s.Args...)
+	return append(buf, s.Body...)
+}
+
+func parseStanza(data []byte) (*Stanza, error) {
+	if len(data) < 2 || len(data) < 4+int(data[1]) {
+		return nil, ErrInvalidHeader
+	}
+	s := Stanza{Type: StanzaType(data[0])}
+	n := 2 + int(data[1])
+	s.Fingerprint = data[2:n]
+	argsLen := int(binary.BigEndian.Uint16(data[n:]))
+	n += 2
+	if len(data) < n+argsLen {
+		return nil, ErrInvalidHeader
+	}
+	s.Args = data[n : n+argsLen]
+	s.Body = data[n+argsLen:]
+	return &s, nil
+}
@@ -0,0 +1,138 @@
+package aes
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptFileToRecipients(t *testing.T) {
+	dir := t.TempDir()
+	in, out, out2, dec := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"),
+		filepath.Join(dir, "out2.txt"), filepath.Join(dir, "dec.txt")
+	data := []byte("hello world")
+	require.NoError(t, os.WriteFile(in, data, 0600))
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	x25519Key, err := GenX25519Key()
+	require.NoError(t, err)
+	x25519Pub, err := x25519Key.Public()
+	require.NoError(t, err)
+	otherKey, err := GenX25519Key()
+	require.NoError(t, err)
+	otherPub, err := otherKey.Public()
+	require.NoError(t, err)
+
+	rsaRecipient := &RSARecipient{PublicKey: &rsaKey.PublicKey}
+	x25519Recipient := &X25519Recipient{PublicKey: x25519Pub}
+	otherRecipient := &X25519Recipient{PublicKey: otherPub}
+
+	require.NoError(t, EncryptFileToRecipients(in, out, []Recipient{rsaRecipient, x25519Recipient}))
+	require.NoError(t, DecryptFileWithIdentities(out, dec, &RSAIdentity{PrivateKey: rsaKey}))
+	requireFile(t, dec, data)
+	_, err = DecryptAny(out, dec, &Keys{X25519: x25519Key})
+	require.NoError(t, err)
+	requireFile(t, dec, data)
+	require.ErrorIs(t, DecryptFileWithIdentities(out, dec, &X25519Identity{PrivateKey: otherKey}), ErrNoIdentity)
+
+	// 增加接收方
+	require.NoError(t, AddRecipients(out, out2, &RSAIdentity{PrivateKey: rsaKey}, otherRecipient))
+	require.NoError(t, DecryptFileWithIdentities(out2, dec, &X25519Identity{PrivateKey: otherKey}))
+	requireFile(t, dec, data)
+
+	// 删除接收方
+	require.NoError(t, RemoveRecipients(out2, out, rsaRecipient, otherRecipient))
+	require.ErrorIs(t, DecryptFileWithIdentities(out, dec, &RSAIdentity{PrivateKey: rsaKey}), ErrNoIdentity)
+	require.NoError(t, DecryptFileWithX25519(out, dec, x25519Key))
+	requireFile(t, dec, data)
+
+	require.ErrorIs(t, RemoveRecipients(out, out2, x25519Recipient), ErrNoRecipient)
+}
+
+func TestRecipientsParts(t *testing.T) {
+	dir := t.TempDir()
+	in, out, out2, dec := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"),
+		filepath.Join(dir, "out2.txt"), filepath.Join(dir, "dec.txt")
+	data := make([]byte, 100*1024)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(in, data, 0600))
+
+	keys := make([]X25519PrivateKey, 3)
+	recipients := make([]Recipient, len(keys))
+	for i := range keys {
+		keys[i], err = GenX25519Key()
+		require.NoError(t, err)
+		pub, err := keys[i].Public()
+		require.NoError(t, err)
+		recipients[i] = &X25519Recipient{PublicKey: pub}
+	}
+	require.NoError(t, EncryptFileToRecipients(in, out, recipients[:2], Opt{SplitSize: 16 * 1024}))
+
+	// 每个分片都只能由剩下的接收方解密
+	requireParts := func(path string, identity Identity, want error) {
+		f, err := SplitEncrypted(path)
+		require.NoError(t, err)
+		require.Len(t, f.Parts, 7)
+		file, err := os.Open(path)
+		require.NoError(t, err)
+		defer file.Close()
+		for _, p := range f.Parts {
+			_, err = VerifyPart(io.NewSectionReader(file, p.Offset, p.Size), &Keys{Identities: []Identity{identity}})
+			require.ErrorIs(t, err, want)
+		}
+	}
+	require.NoError(t, RemoveRecipients(out, out2, recipients[0]))
+	requireParts(out2, &X25519Identity{PrivateKey: keys[0]}, ErrNoIdentity)
+	requireParts(out2, &X25519Identity{PrivateKey: keys[1]}, nil)
+	require.ErrorIs(t, DecryptFileWithIdentities(out2, dec, &X25519Identity{PrivateKey: keys[0]}), ErrNoIdentity)
+	require.NoError(t, DecryptFileWithIdentities(out2, dec, &X25519Identity{PrivateKey: keys[1]}))
+	requireFile(t, dec, data)
+
+	require.NoError(t, AddRecipients(out2, out, &X25519Identity{PrivateKey: keys[1]}, recipients[2]))
+	requireParts(out, &X25519Identity{PrivateKey: keys[2]}, nil)
+	require.NoError(t, DecryptFileWithIdentities(out, dec, &X25519Identity{PrivateKey: keys[2]}))
+	requireFile(t, dec, data)
+
+	// 截断的分片文件
+	enc, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(out, enc[:len(enc)-100], 0600))
+	require.Error(t, RemoveRecipients(out, out2, recipients[1]))
+}
+
+func TestRecipientsBadStanza(t *testing.T) {
+	dir := t.TempDir()
+	in, out, out2, dec := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"),
+		filepath.Join(dir, "out2.txt"), filepath.Join(dir, "dec.txt")
+	data := []byte("hello world")
+	require.NoError(t, os.WriteFile(in, data, 0600))
+	key, err := GenX25519Key()
+	require.NoError(t, err)
+	pub, err := key.Public()
+	require.NoError(t, err)
+	require.NoError(t, EncryptFileToRecipients(in, out, []Recipient{&X25519Recipient{PublicKey: pub}}))
+
+	// 在前面插入指纹相同但无法解开的 Stanza, 不影响解密
+	require.NoError(t, rewriteHeader(out, out2, func(h *Header) error {
+		bad := *h.Recipients[0]
+		bad.Body = make([]byte, len(bad.Body))
+		h.Recipients = append([]*Stanza{&bad}, h.Recipients...)
+		return nil
+	}))
+	require.NoError(t, DecryptFileWithIdentities(out2, dec, &X25519Identity{PrivateKey: key}))
+	requireFile(t, dec, data)
+
+	// 只有无法解开的 Stanza 时返回解开的错误
+	require.NoError(t, rewriteHeader(out2, out, func(h *Header) error {
+		h.Recipients = h.Recipients[:1]
+		return nil
+	}))
+	require.ErrorIs(t, DecryptFileWithIdentities(out, dec, &X25519Identity{PrivateKey: key}), ErrAuth)
+}
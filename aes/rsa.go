@@ -3,10 +3,9 @@ package aes
 import (
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/pem"
-	"errors"
-	"os"
 )
 
 // GenRsaKey 生成密钥对
@@ -25,31 +24,14 @@ func GenRsaPrivateKey(bits int, privatePath string, keyKey []byte) error {
 		Bytes: derPrivateStream,
 	}
 
-	// 4. 创建文件
-	privateFile, err := os.Create(privatePath)
-	if err != nil {
-		return err
-	}
-	defer func() { _ = privateFile.Close() }()
-
+	// 4. 写入文件, 权限 0600
+	data := pem.EncodeToMemory(block)
 	if keyKey != nil {
-		data := pem.EncodeToMemory(block)
-		encryptData, err2 := EncryptGCM(data, keyKey)
-		if err2 != nil {
-			return err2
-		}
-		err = os.WriteFile(privatePath, encryptData, os.ModePerm)
-		if err != nil {
-			return err
-		}
-	} else {
-		err = pem.Encode(privateFile, block)
-		if err != nil {
+		if data, err = EncryptGCM(data, keyKey); err != nil {
 			return err
 		}
 	}
-
-	return nil
+	return writeOutput(privatePath, data, Opt{})
 }
 
 func GenRsaPublicKey(bits int, privatePath, publicPath string, keyKey []byte) error {
@@ -67,50 +49,34 @@ func GenRsaPublicKey(bits int, privatePath, publicPath string, keyKey []byte) er
 		Bytes: derPublicStream,
 	}
 
-	publicFile, err := os.Create(publicPath)
-	if err != nil {
-		return err
-	}
-	defer func() { _ = publicFile.Close() }()
-
 	// 2. 编码公钥, 写入文件
-	err = pem.Encode(publicFile, block)
-	if err != nil {
-		return err
-	}
-	return nil
+	return writeOutput(publicPath, pem.EncodeToMemory(block), Opt{Perm: 0644})
 }
 
+// ReadPublicKey 读取 RSA 公钥, 支持 PKCS#1 和 PKIX
 func ReadPublicKey(keyPath string) (*rsa.PublicKey, error) {
-	key, err := os.ReadFile(keyPath)
+	key, err := ReadAnyPublicKey(keyPath)
 	if err != nil {
 		return nil, err
 	}
-	block, _ := pem.Decode(key)
-	if block == nil {
-		return nil, errors.New("invalid key")
+	pk, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrUnsupportedKey
 	}
-	return x509.ParsePKCS1PublicKey(block.Bytes)
+	return pk, nil
 }
 
+// ReadPrivateKey 读取 RSA 私钥, 支持 PKCS#1 和 PKCS#8
 func ReadPrivateKey(keyPath string, keyKey []byte) (*rsa.PrivateKey, error) {
-	key, err := os.ReadFile(keyPath)
+	key, err := ReadAnyPrivateKey(keyPath, keyKey, nil)
 	if err != nil {
 		return nil, err
 	}
-	if keyKey != nil {
-		key, err = DecryptGCM(key, keyKey)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	block, _ := pem.Decode(key)
-	if block == nil {
-		return nil, errors.New("invalid key")
+	pk, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrUnsupportedKey
 	}
-
-	return x509.ParsePKCS1PrivateKey(block.Bytes)
+	return pk, nil
 }
 
 func RsaEncrypt(src []byte, pk *rsa.PublicKey) ([]byte, error) {
@@ -120,3 +86,13 @@ func RsaEncrypt(src []byte, pk *rsa.PublicKey) ([]byte, error) {
 func RsaDecrypt(src []byte, pk *rsa.PrivateKey) ([]byte, error) {
 	return rsa.DecryptPKCS1v15(rand.Reader, pk, src)
 }
+
+// RsaEncryptOAEP RSA-OAEP(SHA-256) 加密
+func RsaEncryptOAEP(src []byte, pk *rsa.PublicKey) ([]byte, error) {
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, pk, src, nil)
+}
+
+// RsaDecryptOAEP RSA-OAEP(SHA-256) 解密
+func RsaDecryptOAEP(src []byte, pk *rsa.PrivateKey) ([]byte, error) {
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, pk, src, nil)
+}
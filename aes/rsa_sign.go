@@ -0,0 +1,104 @@
+package aes
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+)
+
+const (
+	sigPEMType = "SIGNATURE"
+	sigAlgo    = "Algorithm"
+	// SigRSAPSS RSA-PSS(SHA-256) 签名
+	SigRSAPSS = "RSA-PSS-SHA256"
+)
+
+// ErrInvalidSignature 签名文件格式错误
+var ErrInvalidSignature = errors.New("invalid signature")
+
+var pssOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+
+// SignPSS RSA-PSS(SHA-256) 签名
+func SignPSS(data []byte, pk *rsa.PrivateKey) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	return rsa.SignPSS(rand.Reader, pk, crypto.SHA256, digest[:], pssOpts)
+}
+
+// VerifyPSS 校验 SignPSS 的签名
+func VerifyPSS(data, sig []byte, pk *rsa.PublicKey) error {
+	digest := sha256.Sum256(data)
+	return rsa.VerifyPSS(pk, crypto.SHA256, digest[:], sig, pssOpts)
+}
+
+// SignFilePSS 流式计算文件的 SHA-256 并签名
+func SignFilePSS(path string, pk *rsa.PrivateKey) ([]byte, error) {
+	digest, err := fileSHA256(path)
+	if err != nil {
+		return nil, err
+	}
+	return rsa.SignPSS(rand.Reader, pk, crypto.SHA256, digest, pssOpts)
+}
+
+// VerifyFilePSS 校验 SignFilePSS 的签名
+func VerifyFilePSS(path string, sig []byte, pk *rsa.PublicKey) error {
+	digest, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	return rsa.VerifyPSS(pk, crypto.SHA256, digest, sig, pssOpts)
+}
+
+// WriteSignatureFile 对文件签名, 签名以 PEM 格式写入 sigPath, sigPath 为空时为 path + ".sig"
+func WriteSignatureFile(path, sigPath string, pk *rsa.PrivateKey) error {
+	sig, err := SignFilePSS(path, pk)
+	if err != nil {
+		return err
+	}
+	if sigPath == "" {
+		sigPath = path + ".sig"
+	}
+	block := &pem.Block{
+		Type:    sigPEMType,
+		Headers: map[string]string{sigAlgo: SigRSAPSS},
+		Bytes:   sig,
+	}
+	return writeOutput(sigPath, pem.EncodeToMemory(block), Opt{Perm: 0644})
+}
+
+// VerifySignatureFile 校验 WriteSignatureFile 生成的签名文件, sigPath 为空时为 path + ".sig"
+func VerifySignatureFile(path, sigPath string, pk *rsa.PublicKey) error {
+	if sigPath == "" {
+		sigPath = path + ".sig"
+	}
+	data, err := os.ReadFile(sigPath)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != sigPEMType {
+		return ErrInvalidSignature
+	}
+	if block.Headers[sigAlgo] != SigRSAPSS {
+		return ErrInvalidSignature
+	}
+	return VerifyFilePSS(path, block.Bytes, pk)
+}
+
+func fileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
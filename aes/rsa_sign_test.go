@@ -0,0 +1,64 @@
+package aes
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRsaEncryptOAEP(t *testing.T) {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	source := []byte("hello world")
+	encrypted, err := RsaEncryptOAEP(source, &pk.PublicKey)
+	require.NoError(t, err)
+	decrypted, err := RsaDecryptOAEP(encrypted, pk)
+	require.NoError(t, err)
+	require.Equal(t, source, decrypted)
+
+	_, err = RsaDecrypt(encrypted, pk)
+	require.Error(t, err)
+}
+
+func TestSignPSS(t *testing.T) {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	data := []byte("hello world")
+	sig, err := SignPSS(data, pk)
+	require.NoError(t, err)
+	require.NoError(t, VerifyPSS(data, sig, &pk.PublicKey))
+	require.Error(t, VerifyPSS([]byte("hello world!"), sig, &pk.PublicKey))
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "artifact.bin")
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	require.NoError(t, WriteSignatureFile(path, "", pk))
+	require.NoError(t, VerifySignatureFile(path, "", &pk.PublicKey))
+
+	require.NoError(t, os.WriteFile(path, []byte("hello world!"), 0600))
+	require.Error(t, VerifySignatureFile(path, "", &pk.PublicKey))
+}
+
+func TestEncryptFileWithRSAOAEP(t *testing.T) {
+	dir := t.TempDir()
+	in, out, dec := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"), filepath.Join(dir, "dec.txt")
+	data := []byte("hello world")
+	require.NoError(t, os.WriteFile(in, data, 0600))
+
+	keyKey, err := GenAesKey(32)
+	require.NoError(t, err)
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	require.NoError(t, EncryptFileAndPathWithRSA(in, out, keyKey, &pk.PublicKey, Opt{OAEP: true}))
+	path, err := DecryptFileAndPathWithRSA(out, dec, keyKey, pk)
+	require.NoError(t, err)
+	require.Equal(t, in, path)
+	requireFile(t, dec, data)
+}
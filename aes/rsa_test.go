@@ -3,19 +3,22 @@ package aes
 import (
 	"encoding/base64"
 	"fmt"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
 func TestGenRsaKey(t *testing.T) {
-	privatePath, publicPath := "./id_rsa", "./id_rsa.pub"
+	dir := t.TempDir()
+	privatePath, publicPath := filepath.Join(dir, "id_rsa"), filepath.Join(dir, "id_rsa.pub")
 	require.NoError(t, GenRsaPrivateKey(4096, privatePath, nil))
 	require.NoError(t, GenRsaPublicKey(4096, privatePath, publicPath, nil))
 }
 
 func TestRsaEncrypt(t *testing.T) {
-	privatePath, publicPath := "./id_rsa", "./id_rsa.pub"
+	dir := t.TempDir()
+	privatePath, publicPath := filepath.Join(dir, "id_rsa"), filepath.Join(dir, "id_rsa.pub")
 	require.NoError(t, GenRsaPrivateKey(4096, privatePath, nil))
 	require.NoError(t, GenRsaPublicKey(4096, privatePath, publicPath, nil))
 
@@ -41,7 +44,8 @@ func TestRsaEncrypt(t *testing.T) {
 
 func TestRsaEncryptWithPassword(t *testing.T) {
 	key := Gen256KeyFromPassword([]byte("123"))
-	privatePath, publicPath := "./id_rsa", "./id_rsa.pub"
+	dir := t.TempDir()
+	privatePath, publicPath := filepath.Join(dir, "id_rsa"), filepath.Join(dir, "id_rsa.pub")
 	require.NoError(t, GenRsaPrivateKey(4096, privatePath, key))
 	require.NoError(t, GenRsaPublicKey(4096, privatePath, publicPath, key))
 
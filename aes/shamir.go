@@ -0,0 +1,233 @@
+package aes
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+/*
+Shamir 秘密共享: 在 GF(2^8)(多项式 x^8+x^4+x^3+x+1) 上对秘密的每个字节构造 threshold-1 次随机多项式,
+常数项为秘密, 第 i 份为多项式在 x=i 处的值, 任意 threshold 份可以插值恢复秘密, 更少的份数得不到任何信息.
+份额编码: "HXS1-" + base32(setID(4) | threshold(1) | index(1) | 值 | sha256 前 4 字节), 每 8 个字符以 - 分隔,
+setID 随机生成, 用于发现混用了不同秘密的份额. 解析时忽略大小写, 空白和 -
+*/
+
+var (
+	// ErrInvalidShare 份额格式错误或校验失败
+	ErrInvalidShare = errors.New("invalid share")
+	// ErrShareMismatch 份额不属于同一个秘密或重复
+	ErrShareMismatch = errors.New("shares mismatch")
+	// ErrNotEnoughShares 份额数量少于 threshold
+	ErrNotEnoughShares = errors.New("not enough shares")
+	// ErrInvalidThreshold threshold 或份数不合法
+	ErrInvalidThreshold = errors.New("invalid threshold")
+)
+
+const (
+	sharePrefix   = "HXS1-"
+	shareChecksum = 4
+	shareGroup    = 8
+)
+
+var shareEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Share 一份秘密
+type Share struct {
+	SetID     uint32 // 同一次 SplitSecret 产生的份额相同
+	Threshold byte   // 恢复秘密需要的份数
+	Index     byte   // x 坐标, 从 1 开始
+	Value     []byte // 与秘密等长
+}
+
+// SplitSecret 把 secret 拆分为 n 份, 任意 threshold 份可以恢复, 2 <= threshold <= n <= 255
+func SplitSecret(secret []byte, n, threshold int) ([]*Share, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, ErrInvalidThreshold
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("empty secret")
+	}
+	var id [4]byte
+	if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
+		return nil, err
+	}
+	shares := make([]*Share, n)
+	for i := range shares {
+		shares[i] = &Share{
+			SetID:     binary.BigEndian.Uint32(id[:]),
+			Threshold: byte(threshold),
+			Index:     byte(i + 1),
+			Value:     make([]byte, len(secret)),
+		}
+	}
+	// coef[0] 为秘密, 其余为随机系数
+	coef := make([]byte, threshold)
+	for j, b := range secret {
+		coef[0] = b
+		if _, err := io.ReadFull(rand.Reader, coef[1:]); err != nil {
+			return nil, err
+		}
+		for _, s := range shares {
+			s.Value[j] = gfEval(coef, s.Index)
+		}
+	}
+	for i := range coef {
+		coef[i] = 0
+	}
+	return shares, nil
+}
+
+// CombineShares 从至少 threshold 份恢复秘密, 多于 threshold 时只使用前 threshold 份
+func CombineShares(shares []*Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, ErrNotEnoughShares
+	}
+	first := shares[0]
+	if first.Threshold < 2 || first.Index == 0 {
+		return nil, ErrInvalidShare
+	}
+	if len(shares) < int(first.Threshold) {
+		return nil, ErrNotEnoughShares
+	}
+	shares = shares[:first.Threshold]
+	seen := make(map[byte]bool, len(shares))
+	for _, s := range shares {
+		if s.SetID != first.SetID || s.Threshold != first.Threshold || len(s.Value) != len(first.Value) || seen[s.Index] {
+			return nil, ErrShareMismatch
+		}
+		if s.Index == 0 {
+			return nil, ErrInvalidShare
+		}
+		seen[s.Index] = true
+	}
+
+	// 拉格朗日插值求 x=0 处的值, l_i = prod(x_j / (x_j - x_i)), 加减法均为异或
+	secret := make([]byte, len(first.Value))
+	for i, si := range shares {
+		l := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				l = gfMul(l, gfMul(sj.Index, gfInv(sj.Index^si.Index)))
+			}
+		}
+		for k, v := range si.Value {
+			secret[k] ^= gfMul(l, v)
+		}
+	}
+	return secret, nil
+}
+
+// String 编码为可打印的份额
+func (s *Share) String() string {
+	buf := make([]byte, 0, 6+len(s.Value)+shareChecksum)
+	buf = binary.BigEndian.AppendUint32(buf, s.SetID)
+	buf = append(buf, s.Threshold, s.Index)
+	buf = append(buf, s.Value...)
+	sum := sha256.Sum256(buf)
+	buf = append(buf, sum[:shareChecksum]...)
+
+	encoded := shareEncoding.EncodeToString(buf)
+	var b strings.Builder
+	b.WriteString(sharePrefix)
+	for i := 0; i < len(encoded); i += shareGroup {
+		if i > 0 {
+			b.WriteByte('-')
+		}
+		end := i + shareGroup
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		b.WriteString(encoded[i:end])
+	}
+	return b.String()
+}
+
+// ParseShare 解析 Share.String 的输出并校验
+func ParseShare(s string) (*Share, error) {
+	s = strings.ToUpper(strings.ReplaceAll(strings.Join(strings.Fields(s), ""), "-", ""))
+	prefix := strings.TrimSuffix(sharePrefix, "-")
+	if !strings.HasPrefix(s, prefix) {
+		return nil, ErrInvalidShare
+	}
+	buf, err := shareEncoding.DecodeString(s[len(prefix):])
+	if err != nil || len(buf) < 7+shareChecksum {
+		return nil, ErrInvalidShare
+	}
+	n := len(buf) - shareChecksum
+	sum := sha256.Sum256(buf[:n])
+	if !bytes.Equal(sum[:shareChecksum], buf[n:]) {
+		return nil, ErrInvalidShare
+	}
+	share := &Share{
+		SetID:     binary.BigEndian.Uint32(buf),
+		Threshold: buf[4],
+		Index:     buf[5],
+		Value:     buf[6:n],
+	}
+	if share.Threshold < 2 || share.Index == 0 {
+		return nil, ErrInvalidShare
+	}
+	return share, nil
+}
+
+// SplitKey 同 SplitSecret, 返回编码后的份额
+func SplitKey(key []byte, n, threshold int) ([]string, error) {
+	shares, err := SplitSecret(key, n, threshold)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, len(shares))
+	for i, s := range shares {
+		out[i] = s.String()
+	}
+	return out, nil
+}
+
+// CombineKey 解析编码后的份额并恢复秘密
+func CombineKey(shares []string) ([]byte, error) {
+	parsed := make([]*Share, len(shares))
+	for i, s := range shares {
+		share, err := ParseShare(s)
+		if err != nil {
+			return nil, err
+		}
+		parsed[i] = share
+	}
+	return CombineShares(parsed)
+}
+
+// Horner 法求多项式在 x 处的值
+func gfEval(coef []byte, x byte) byte {
+	var y byte
+	for i := len(coef) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coef[i]
+	}
+	return y
+}
+
+// GF(2^8) 乘法, 不查表, 运行时间与数据无关
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		b >>= 1
+		a = a<<1 ^ -(a>>7)&0x1b
+	}
+	return p
+}
+
+// a^254 = a^-1, a 不能为 0
+func gfInv(a byte) byte {
+	r := a
+	for i := 0; i < 6; i++ {
+		a = gfMul(a, a)
+		r = gfMul(r, a)
+	}
+	return gfMul(r, r)
+}
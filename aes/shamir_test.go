@@ -0,0 +1,61 @@
+package aes
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShamir(t *testing.T) {
+	for i := 1; i < 256; i++ {
+		require.Equal(t, byte(1), gfMul(byte(i), gfInv(byte(i))), i)
+	}
+
+	key, err := GenAesKey(32)
+	require.NoError(t, err)
+	shares, err := SplitKey(key, 5, 3)
+	require.NoError(t, err)
+	require.Len(t, shares, 5)
+
+	// 任意 3 份都可以恢复
+	for _, idx := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var subset []string
+		for _, i := range idx {
+			subset = append(subset, shares[i])
+		}
+		got, err := CombineKey(subset)
+		require.NoError(t, err)
+		require.Equal(t, key, got)
+	}
+	_, err = CombineKey(shares[:2])
+	require.ErrorIs(t, err, ErrNotEnoughShares)
+	_, err = CombineKey([]string{shares[0], shares[0], shares[1]})
+	require.ErrorIs(t, err, ErrShareMismatch)
+
+	// 解析时忽略大小写和空白
+	got, err := CombineKey([]string{strings.ToLower(shares[0]), " " + shares[1] + "\n", strings.ReplaceAll(shares[2], "-", " ")})
+	require.NoError(t, err)
+	require.Equal(t, key, got)
+
+	// 抄错一个字符
+	b := []byte(shares[1])
+	if b[10] == 'A' {
+		b[10] = 'B'
+	} else {
+		b[10] = 'A'
+	}
+	_, err = ParseShare(string(b))
+	require.ErrorIs(t, err, ErrInvalidShare)
+
+	// 不同秘密的份额不能混用
+	other, err := SplitKey(key, 3, 3)
+	require.NoError(t, err)
+	_, err = CombineKey([]string{shares[0], shares[1], other[2]})
+	require.ErrorIs(t, err, ErrShareMismatch)
+
+	_, err = SplitKey(key, 3, 1)
+	require.ErrorIs(t, err, ErrInvalidThreshold)
+	_, err = SplitKey(key, 256, 2)
+	require.ErrorIs(t, err, ErrInvalidThreshold)
+}
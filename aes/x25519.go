@@ -0,0 +1,170 @@
+package aes
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+/*
+X25519 混合加密(ECIES):
+	每个文件生成临时密钥对, 与接收方公钥协商出共享密钥
+	wrapKey = HKDF-SHA256(共享密钥, 临时公钥 | 接收方公钥, "hxaes x25519")
+	文件头保存临时公钥和 AES-GCM(wrapKey, 内容密钥)
+*/
+
+// ErrInvalidX25519Key X25519 密钥格式错误
+var ErrInvalidX25519Key = errors.New("invalid x25519 key")
+
+// X25519PrivateKey X25519 私钥, 32 字节
+type X25519PrivateKey []byte
+
+// X25519PublicKey X25519 公钥, 32 字节
+type X25519PublicKey []byte
+
+// GenX25519Key 生成 X25519 私钥
+func GenX25519Key() (X25519PrivateKey, error) {
+	return GenAesKey(curve25519.ScalarSize)
+}
+
+// Public 返回私钥对应的公钥
+func (k X25519PrivateKey) Public() (X25519PublicKey, error) {
+	if len(k) != curve25519.ScalarSize {
+		return nil, ErrInvalidX25519Key
+	}
+	return curve25519.X25519(k, curve25519.Basepoint)
+}
+
+// String base64 编码
+func (k X25519PrivateKey) String() string {
+	return base64.StdEncoding.EncodeToString(k)
+}
+
+// String base64 编码
+func (k X25519PublicKey) String() string {
+	return base64.StdEncoding.EncodeToString(k)
+}
+
+// ParseX25519PrivateKey 解析 base64 编码的私钥
+func ParseX25519PrivateKey(s string) (X25519PrivateKey, error) {
+	k, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(k) != curve25519.ScalarSize {
+		return nil, ErrInvalidX25519Key
+	}
+	return k, nil
+}
+
+// ParseX25519PublicKey 解析 base64 编码的公钥
+func ParseX25519PublicKey(s string) (X25519PublicKey, error) {
+	k, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(k) != curve25519.PointSize {
+		return nil, ErrInvalidX25519Key
+	}
+	return k, nil
+}
+
+// NewEncryptWriterWithX25519 随机生成 key 加密数据, key 经 X25519 协商的密钥加密后写入文件头
+func NewEncryptWriterWithX25519(w io.Writer, pub X25519PublicKey, opts ...Opt) (io.WriteCloser, error) {
+	h, key, err := newX25519Header(pub)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptWriter(w, h, key, getOpt(opts...))
+}
+
+// NewDecryptReaderWithX25519 解密 NewEncryptWriterWithX25519 的输出
+func NewDecryptReaderWithX25519(r io.Reader, priv X25519PrivateKey) (*Reader, error) {
+	return NewDecryptReaderAny(r, &Keys{X25519: priv})
+}
+
+// EncryptFileWithX25519 使用 X25519 公钥加密文件
+func EncryptFileWithX25519(in, out string, pub X25519PublicKey, opts ...Opt) error {
+	return EncryptFileWithX25519Context(context.Background(), in, out, pub, opts...)
+}
+
+func EncryptFileWithX25519Context(ctx context.Context, in, out string, pub X25519PublicKey, opts ...Opt) error {
+	return encryptFile(ctx, in, out, getOpt(opts...), func() (*Header, []byte, error) {
+		return newX25519Header(pub)
+	})
+}
+
+func DecryptFileWithX25519(in, out string, priv X25519PrivateKey, opts ...Opt) error {
+	_, err := DecryptAny(in, out, &Keys{X25519: priv}, opts...)
+	return err
+}
+
+// 随机生成 key, 经 X25519 协商的密钥加密后存于文件头
+func newX25519Header(pub X25519PublicKey) (*Header, []byte, error) {
+	key, err := GenAesKey(32)
+	if err != nil {
+		return nil, nil, err
+	}
+	ephemeral, wrapped, err := wrapX25519(key, pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Header{KDF: KDFX25519, Ephemeral: ephemeral, WrappedKey: wrapped}, key, nil
+}
+
+// 返回临时公钥和被加密的 key
+func wrapX25519(key []byte, pub X25519PublicKey) ([]byte, []byte, error) {
+	ephemeralKey, err := GenX25519Key()
+	if err != nil {
+		return nil, nil, err
+	}
+	ephemeral, err := ephemeralKey.Public()
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := x25519AEAD(ephemeralKey, pub, ephemeral, pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ephemeral, aead.Seal(nil, make([]byte, aead.NonceSize()), key, nil), nil
+}
+
+func unwrapX25519(wrapped, ephemeral []byte, priv X25519PrivateKey) ([]byte, error) {
+	pub, err := priv.Public()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := x25519AEAD(priv, ephemeral, ephemeral, pub)
+	if err != nil {
+		return nil, err
+	}
+	key, err := aead.Open(nil, make([]byte, aead.NonceSize()), wrapped, nil)
+	if err != nil {
+		return nil, ErrAuth
+	}
+	return key, nil
+}
+
+// priv 与 peer 协商共享密钥, 每个临时密钥只使用一次, nonce 可以固定为 0
+func x25519AEAD(priv X25519PrivateKey, peer, ephemeral, pub []byte) (cipher.AEAD, error) {
+	if len(ephemeral) != curve25519.PointSize || len(pub) != curve25519.PointSize {
+		return nil, ErrInvalidX25519Key
+	}
+	shared, err := curve25519.X25519(priv, peer)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 0, len(ephemeral)+len(pub))
+	salt = append(salt, ephemeral...)
+	salt = append(salt, pub...)
+	wrapKey := make([]byte, 32)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("hxaes x25519")), wrapKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
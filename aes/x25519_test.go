@@ -0,0 +1,71 @@
+package aes
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestX25519Key(t *testing.T) {
+	priv, err := GenX25519Key()
+	require.NoError(t, err)
+	pub, err := priv.Public()
+	require.NoError(t, err)
+
+	priv2, err := ParseX25519PrivateKey(priv.String())
+	require.NoError(t, err)
+	require.Equal(t, priv, priv2)
+	pub2, err := ParseX25519PublicKey(pub.String())
+	require.NoError(t, err)
+	require.Equal(t, pub, pub2)
+
+	_, err = ParseX25519PublicKey("hello")
+	require.ErrorIs(t, err, ErrInvalidX25519Key)
+}
+
+func TestEncryptWriterWithX25519(t *testing.T) {
+	priv, err := GenX25519Key()
+	require.NoError(t, err)
+	pub, err := priv.Public()
+	require.NoError(t, err)
+	data := bytes.Repeat([]byte("hello world"), 10000)
+
+	var buf bytes.Buffer
+	w, err := NewEncryptWriterWithX25519(&buf, pub)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.Less(t, buf.Len()-len(data), 512)
+
+	r, err := NewDecryptReaderWithX25519(bytes.NewReader(buf.Bytes()), priv)
+	require.NoError(t, err)
+	dec, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, data, dec)
+
+	other, err := GenX25519Key()
+	require.NoError(t, err)
+	_, err = NewDecryptReaderWithX25519(bytes.NewReader(buf.Bytes()), other)
+	require.ErrorIs(t, err, ErrAuth)
+}
+
+func TestEncryptFileWithX25519(t *testing.T) {
+	dir := t.TempDir()
+	in, out, dec := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"), filepath.Join(dir, "dec.txt")
+	data := []byte("hello world")
+	require.NoError(t, os.WriteFile(in, data, 0600))
+
+	priv, err := GenX25519Key()
+	require.NoError(t, err)
+	pub, err := priv.Public()
+	require.NoError(t, err)
+
+	require.NoError(t, EncryptFileWithX25519(in, out, pub))
+	require.NoError(t, DecryptFileWithX25519(out, dec, priv))
+	requireFile(t, dec, data)
+}
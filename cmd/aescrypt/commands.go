@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/happyxhw/pkg/aes"
+	"github.com/happyxhw/pkg/fsutil"
+)
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("aescrypt "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+func runKeygen(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("keygen", stderr)
+	typ := fs.String("type", "aes", "密钥类型: aes, rsa 或 x25519")
+	out := fs.String("out", "", "私钥文件, 权限 0600")
+	pub := fs.String("pub", "", "公钥文件, 默认为 -out 加 .pub")
+	bits := fs.Int("bits", 0, "密钥长度, rsa 默认 4096, aes 默认 256")
+	keyKey := fs.String("keykey", "", "rsa: 使用 AES 密钥(base64)加密私钥文件, 同 aes.GenRsaPrivateKey")
+	passphrase := fs.String("passphrase", "", "rsa: 使用口令加密 PKCS#8 私钥: env:NAME, file:PATH 或 prompt")
+	force := fs.Bool("force", false, "覆盖已存在的文件")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return missing(fs, "out")
+	}
+	if *pub == "" {
+		*pub = *out + ".pub"
+	}
+
+	switch *typ {
+	case "aes":
+		if *bits == 0 {
+			*bits = 256
+		}
+		if !validKeySize(*bits / 8) {
+			return fmt.Errorf("invalid aes key bits %d", *bits)
+		}
+		if err := checkOutput(*force, *out); err != nil {
+			return err
+		}
+		key, err := aes.GenAesKey(*bits / 8)
+		if err != nil {
+			return err
+		}
+		return fsutil.WriteFileAtomic(*out, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), fsutil.AtomicOpt{Perm: 0600})
+	case "x25519":
+		if err := checkOutput(*force, *out, *pub); err != nil {
+			return err
+		}
+		key, err := aes.GenX25519Key()
+		if err != nil {
+			return err
+		}
+		pk, err := key.Public()
+		if err != nil {
+			return err
+		}
+		if err = fsutil.WriteFileAtomic(*out, []byte(key.String()+"\n"), fsutil.AtomicOpt{Perm: 0600}); err != nil {
+			return err
+		}
+		if err = fsutil.WriteFileAtomic(*pub, []byte(pk.String()+"\n"), fsutil.AtomicOpt{Perm: 0644}); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, pk.String())
+		return nil
+	case "rsa":
+		if *bits == 0 {
+			*bits = 4096
+		}
+		if *keyKey != "" && *passphrase != "" {
+			fmt.Fprintln(stderr, "-keykey and -passphrase are mutually exclusive")
+			fs.Usage()
+			return errUsage
+		}
+		if err := checkOutput(*force, *out, *pub); err != nil {
+			return err
+		}
+		if *keyKey != "" {
+			kk, err := loadKey(*keyKey, "keykey")
+			if err != nil {
+				return err
+			}
+			if err = aes.GenRsaPrivateKey(*bits, *out, kk); err != nil {
+				return err
+			}
+			return aes.GenRsaPublicKey(*bits, *out, *pub, kk)
+		}
+		var pass []byte
+		if *passphrase != "" {
+			var err error
+			if pass, err = loadPassword(*passphrase, "passphrase", true); err != nil {
+				return err
+			}
+		}
+		key, err := aes.GenerateKey(aes.KeyRSA, *bits)
+		if err != nil {
+			return err
+		}
+		if err = aes.WritePrivateKey(*out, key, pass); err != nil {
+			return err
+		}
+		return aes.WritePublicKey(*pub, key.Public())
+	default:
+		fmt.Fprintf(stderr, "unknown key type %q\n", *typ)
+		fs.Usage()
+		return errUsage
+	}
+}
+
+// 输出文件已存在且没有 -force 时返回 os.ErrExist
+func checkOutput(force bool, paths ...string) error {
+	if force {
+		return nil
+	}
+	for _, p := range paths {
+		if _, err := os.Lstat(p); err == nil {
+			return &os.PathError{Op: "create", Path: p, Err: os.ErrExist}
+		}
+	}
+	return nil
+}
+
+var codecs = map[string]aes.Codec{
+	"none": aes.CodecNone,
+	"gzip": aes.CodecGzip,
+	"zstd": aes.CodecZstd,
+}
+
+func runEncrypt(args []string, _, stderr io.Writer) error {
+	fs := newFlagSet("encrypt", stderr)
+	in := fs.String("in", "", "输入文件")
+	out := fs.String("out", "", "输出文件, 默认为 -in 加 .enc")
+	path := fs.String("path", "", "写入文件头的原始路径, 解密时 -out 为空则解密到该路径")
+	compress := fs.String("compress", "none", "加密前压缩: none, gzip 或 zstd, 输入已经是压缩数据时不压缩")
+	split := fs.Int64("split", 0, "大于 0 时按该大小分片并发加密, 不能与 -compress 同时使用")
+	noOverwrite := fs.Bool("no-overwrite", false, "输出文件已存在时失败, 退出码 4")
+	var ef encryptFlags
+	ef.register(fs, "")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return missing(fs, "in")
+	}
+	if *out == "" {
+		*out = *in + ".enc"
+	}
+	codec, ok := codecs[*compress]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown codec %q\n", *compress)
+		fs.Usage()
+		return errUsage
+	}
+	e, err := ef.load(fs, "")
+	if err != nil {
+		return err
+	}
+	return e.encryptFile(*in, *out, aes.Opt{
+		Path:        *path,
+		SplitSize:   *split,
+		Compress:    codec,
+		NoOverwrite: *noOverwrite,
+	})
+}
+
+func runDecrypt(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("decrypt", stderr)
+	in := fs.String("in", "", "输入文件")
+	out := fs.String("out", "", "输出文件, 为空时使用文件头中的原始路径, 仅允许当前目录下的相对路径")
+	noOverwrite := fs.Bool("no-overwrite", false, "输出文件已存在时失败, 退出码 4")
+	var kf keyFlags
+	kf.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return missing(fs, "in")
+	}
+	keys, err := kf.load(fs)
+	if err != nil {
+		return err
+	}
+	path, err := aes.DecryptAny(*in, *out, keys, aes.Opt{NoOverwrite: *noOverwrite})
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		fmt.Fprintln(stdout, path)
+	}
+	return nil
+}
+
+var (
+	cipherNames = map[aes.CipherID]string{
+		aes.CipherAES256CTR:      "aes-256-ctr",
+		aes.CipherAES256GCMChunk: "aes-256-gcm-chunk",
+	}
+	kdfNames = map[aes.KDFID]string{
+		aes.KDFRaw:        "raw",
+		aes.KDFRSA:        "rsa",
+		aes.KDFArgon2id:   "argon2id",
+		aes.KDFScrypt:     "scrypt",
+		aes.KDFPBKDF2:     "pbkdf2",
+		aes.KDFRSAOAEP:    "rsa-oaep",
+		aes.KDFX25519:     "x25519",
+		aes.KDFRecipients: "recipients",
+		aes.KDFProvider:   "provider",
+	}
+	codecNames = map[aes.Codec]string{
+		aes.CodecNone: "none",
+		aes.CodecGzip: "gzip",
+		aes.CodecZstd: "zstd",
+	}
+	stanzaNames = map[aes.StanzaType]string{
+		aes.StanzaRSAOAEP: "rsa-oaep",
+		aes.StanzaX25519:  "x25519",
+	}
+)
+
+func name[K comparable](m map[K]string, k K) string {
+	if s, ok := m[k]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown(%v)", k)
+}
+
+func runInspect(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("inspect-header", stderr)
+	in := fs.String("in", "", "加密文件")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return missing(fs, "in")
+	}
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h, err := aes.ReadHeader(f)
+	if errors.Is(err, aes.ErrNoHeader) {
+		fmt.Fprintln(stdout, "format:      legacy (no header)")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "version:     %d\n", h.Version)
+	fmt.Fprintf(stdout, "cipher:      %s\n", name(cipherNames, h.Cipher))
+	fmt.Fprintf(stdout, "kdf:         %s\n", name(kdfNames, h.KDF))
+	fmt.Fprintf(stdout, "fixed iv:    %t\n", h.Flags&aes.FlagFixedIV != 0)
+	fmt.Fprintf(stdout, "path:        %t\n", h.Flags&aes.FlagPath != 0)
+	if h.KeyID != "" {
+		fmt.Fprintf(stdout, "key id:      %s\n", h.KeyID)
+	}
+	if h.ChunkSize > 0 {
+		fmt.Fprintf(stdout, "chunk size:  %d\n", h.ChunkSize)
+	}
+	fmt.Fprintf(stdout, "compress:    %s\n", name(codecNames, h.Codec))
+	for _, s := range h.Recipients {
+		fmt.Fprintf(stdout, "recipient:   %s %s\n", name(stanzaNames, s.Type), hex.EncodeToString(s.Fingerprint))
+	}
+	if h.Part != nil {
+		fmt.Fprintf(stdout, "part:        %d/%d offset %d size %d file %s\n",
+			h.Part.Index+1, h.Part.Count, h.Part.Offset, h.Part.Size, hex.EncodeToString(h.Part.FileID))
+	}
+	fmt.Fprintf(stdout, "header size: %d\n", h.Size())
+	return nil
+}
+
+func runRewrap(args []string, _, stderr io.Writer) error {
+	fs := newFlagSet("rewrap", stderr)
+	in := fs.String("in", "", "输入文件")
+	out := fs.String("out", "", "输出文件, 可以与 -in 相同")
+	compress := fs.String("compress", "", "加密前压缩: none, gzip 或 zstd, 默认与原文件相同")
+	noOverwrite := fs.Bool("no-overwrite", false, "输出文件已存在时失败, 退出码 4")
+	var kf keyFlags
+	kf.register(fs)
+	var ef encryptFlags
+	ef.register(fs, "new-")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return missing(fs, "in")
+	}
+	if *out == "" {
+		return missing(fs, "out")
+	}
+	codec, ok := codecs[*compress]
+	if !ok && *compress != "" {
+		fmt.Fprintf(stderr, "unknown codec %q\n", *compress)
+		fs.Usage()
+		return errUsage
+	}
+	keys, err := kf.load(fs)
+	if err != nil {
+		return err
+	}
+	e, err := ef.load(fs, "new-")
+	if err != nil {
+		return err
+	}
+	// 提前检查, 避免无用的解密; 改名时仍会再次检查
+	if *noOverwrite {
+		if err = checkOutput(false, *out); err != nil {
+			return err
+		}
+	}
+	return rewrap(*in, *out, keys, e, codec, *compress != "", *noOverwrite)
+}
+
+// 解密后使用新的密钥加密, 保留原始路径, 输出通过 fsutil.AtomicFile 原子写入.
+// noOverwrite 时目标已经存在(包括检查之后被其他进程创建)时返回 os.ErrExist
+func rewrap(in, out string, keys *aes.Keys, e *encrypter, codec aes.Codec, setCodec, noOverwrite bool) error {
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := aes.NewDecryptReaderAny(f, keys)
+	if err != nil {
+		return err
+	}
+	opt := aes.Opt{Path: r.Path()}
+	if h := r.Header(); h != nil {
+		if h.Part != nil && h.Part.Count > 1 {
+			return errors.New("split files are not supported, decrypt first")
+		}
+		opt.Compress = h.Codec
+	}
+	if setCodec {
+		opt.Compress = codec
+	}
+
+	tmp, err := fsutil.CreateAtomic(out, fsutil.AtomicOpt{Perm: 0600, NoOverwrite: noOverwrite})
+	if err != nil {
+		return err
+	}
+	defer tmp.Close()
+	w, err := e.newWriter(tmp, opt)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return tmp.Commit()
+}
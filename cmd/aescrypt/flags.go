@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/happyxhw/pkg/aes"
+)
+
+// 加密方式, 只能指定一种
+type encryptFlags struct {
+	key       string
+	keyKey    string
+	rsaPub    string
+	oaep      bool
+	x25519Pub string
+	password  string
+	keyring   string
+}
+
+func (f *encryptFlags) register(fs *flag.FlagSet, prefix string) {
+	fs.StringVar(&f.key, prefix+"key", "", "AES 密钥(base64): env:NAME, file:PATH 或 prompt")
+	fs.StringVar(&f.keyKey, prefix+"keykey", "", "RSA 模式加密随机 key 的 AES 密钥(base64)")
+	fs.StringVar(&f.rsaPub, prefix+"rsa-pub", "", "RSA 公钥文件, 需要同时指定 -"+prefix+"keykey")
+	fs.BoolVar(&f.oaep, prefix+"oaep", false, "RSA 使用 OAEP")
+	fs.StringVar(&f.x25519Pub, prefix+"x25519-pub", "", "X25519 公钥文件")
+	fs.StringVar(&f.password, prefix+"password", "", "口令: env:NAME, file:PATH 或 prompt")
+	fs.StringVar(&f.keyring, prefix+"keyring", "", "keyring(id:base64, 最后一个为当前密钥): env:NAME 或 file:PATH")
+}
+
+// 加载密钥, 必须且只能指定一种加密方式
+func (f *encryptFlags) load(fs *flag.FlagSet, prefix string) (*encrypter, error) {
+	n := 0
+	for _, s := range []string{f.key, f.rsaPub, f.x25519Pub, f.password, f.keyring} {
+		if s != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		fmt.Fprintf(fs.Output(), "exactly one of -%[1]skey, -%[1]srsa-pub, -%[1]sx25519-pub, -%[1]spassword, -%[1]skeyring is required\n", prefix)
+		fs.Usage()
+		return nil, errUsage
+	}
+
+	var e encrypter
+	var err error
+	switch {
+	case f.key != "":
+		e.key, err = loadKey(f.key, prefix+"key")
+	case f.rsaPub != "":
+		if f.keyKey == "" {
+			return nil, missing(fs, prefix+"keykey")
+		}
+		e.oaep = f.oaep
+		if e.keyKey, err = loadKey(f.keyKey, prefix+"keykey"); err == nil {
+			e.rsaPub, err = aes.ReadPublicKey(f.rsaPub)
+		}
+	case f.x25519Pub != "":
+		e.x25519Pub, err = loadX25519PublicKey(f.x25519Pub)
+	case f.password != "":
+		e.password, err = loadPassword(f.password, prefix+"password", true)
+	case f.keyring != "":
+		e.keyring, err = loadKeyring(f.keyring)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+type encrypter struct {
+	key       []byte
+	keyKey    []byte
+	rsaPub    *rsa.PublicKey
+	oaep      bool
+	x25519Pub aes.X25519PublicKey
+	password  []byte
+	keyring   *aes.Keyring
+}
+
+func (e *encrypter) encryptFile(in, out string, opt aes.Opt) error {
+	switch {
+	case e.rsaPub != nil:
+		opt.OAEP = e.oaep
+		return aes.EncryptFileWithRSA(in, out, e.keyKey, e.rsaPub, opt)
+	case e.x25519Pub != nil:
+		return aes.EncryptFileWithX25519(in, out, e.x25519Pub, opt)
+	case e.password != nil:
+		return aes.EncryptFileWithPassword(in, out, e.password, opt)
+	case e.keyring != nil:
+		return aes.EncryptFileWithKeyring(in, out, e.keyring, opt)
+	default:
+		return aes.EncryptFileContext(context.Background(), in, out, e.key, opt)
+	}
+}
+
+func (e *encrypter) newWriter(w io.Writer, opt aes.Opt) (io.WriteCloser, error) {
+	switch {
+	case e.rsaPub != nil:
+		opt.OAEP = e.oaep
+		return aes.NewEncryptWriterWithRSA(w, e.keyKey, e.rsaPub, opt)
+	case e.x25519Pub != nil:
+		return aes.NewEncryptWriterWithX25519(w, e.x25519Pub, opt)
+	case e.password != nil:
+		return aes.NewEncryptWriterWithPassword(w, e.password, opt)
+	case e.keyring != nil:
+		return aes.NewEncryptWriterWithKeyring(w, e.keyring, opt)
+	default:
+		return aes.NewEncryptWriter(w, e.key, opt)
+	}
+}
+
+// 解密用的密钥, 可以同时指定多种, 按文件头选择
+type keyFlags struct {
+	key        string
+	keyKey     string
+	rsaKey     string
+	passphrase string
+	x25519Key  string
+	password   string
+	keyring    string
+}
+
+func (f *keyFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.key, "key", "", "AES 密钥(base64): env:NAME, file:PATH 或 prompt")
+	fs.StringVar(&f.keyKey, "keykey", "", "RSA 模式加密随机 key 的 AES 密钥(base64), 也用于读取 GenRsaPrivateKey 加密的私钥")
+	fs.StringVar(&f.rsaKey, "rsa-key", "", "RSA 私钥文件")
+	fs.StringVar(&f.passphrase, "passphrase", "", "RSA 私钥文件的口令, 未指定且私钥被加密时从终端读取")
+	fs.StringVar(&f.x25519Key, "x25519-key", "", "X25519 私钥: env:NAME 或 file:PATH")
+	fs.StringVar(&f.password, "password", "", "口令: env:NAME, file:PATH 或 prompt")
+	fs.StringVar(&f.keyring, "keyring", "", "keyring: env:NAME 或 file:PATH")
+}
+
+func (f *keyFlags) load(fs *flag.FlagSet) (*aes.Keys, error) {
+	if f.key == "" && f.rsaKey == "" && f.x25519Key == "" && f.password == "" && f.keyring == "" {
+		fmt.Fprintln(fs.Output(), "one of -key, -rsa-key, -x25519-key, -password, -keyring is required")
+		fs.Usage()
+		return nil, errUsage
+	}
+
+	var keys aes.Keys
+	var err error
+	if f.key != "" {
+		if keys.Key, err = loadKey(f.key, "key"); err != nil {
+			return nil, err
+		}
+	}
+	if f.keyKey != "" {
+		if keys.KeyKey, err = loadKey(f.keyKey, "keykey"); err != nil {
+			return nil, err
+		}
+	}
+	if f.rsaKey != "" {
+		if keys.PrivateKey, err = loadRSAKey(f.rsaKey, keys.KeyKey, f.passphrase); err != nil {
+			return nil, err
+		}
+	}
+	if f.x25519Key != "" {
+		if keys.X25519, err = loadX25519Key(f.x25519Key); err != nil {
+			return nil, err
+		}
+	}
+	if f.password != "" {
+		if keys.Password, err = loadPassword(f.password, "password", false); err != nil {
+			return nil, err
+		}
+	}
+	if f.keyring != "" {
+		if keys.Keyring, err = loadKeyring(f.keyring); err != nil {
+			return nil, err
+		}
+	}
+	return &keys, nil
+}
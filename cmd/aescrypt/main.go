@@ -0,0 +1,140 @@
+// Command aescrypt 命令行加解密工具, 基于 github.com/happyxhw/pkg/aes.
+//
+//	aescrypt keygen  -type rsa -out id_rsa -pub id_rsa.pub -passphrase
+//	aescrypt encrypt -in backup.tar -out backup.tar.enc -rsa-pub id_rsa.pub -keykey env:KEY_KEY
+//	aescrypt decrypt -in backup.tar.enc -out backup.tar -rsa-key id_rsa -keykey env:KEY_KEY
+//	aescrypt inspect-header -in backup.tar.enc
+//	aescrypt rewrap  -in old.enc -out new.enc -key file:old.key -new-key file:new.key
+//
+// 密钥和口令通过 env:NAME, file:PATH 或 prompt(从终端读取, 不回显) 指定, 不支持直接写在命令行中.
+// AES 密钥为 base64 编码.
+//
+// 退出码:
+//
+//	0 成功
+//	1 其他错误
+//	2 参数错误
+//	3 密钥错误, 缺少密钥或数据被篡改
+//	4 输出文件已存在(-no-overwrite)
+package main
+
+import (
+	"crypto/rsa"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/happyxhw/pkg/aes"
+)
+
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+	exitAuth
+	exitExists
+)
+
+// errUsage 参数错误, 已输出提示
+var errUsage = errors.New("usage error")
+
+type command struct {
+	name    string
+	aliases []string // 旧的命令名
+	usage   string
+	run     func(args []string, stdout, stderr io.Writer) error
+}
+
+func (c *command) match(name string) bool {
+	if c.name == name {
+		return true
+	}
+	for _, a := range c.aliases {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+var commands = []command{
+	{"keygen", nil, "生成 rsa, x25519 或 aes 密钥", runKeygen},
+	{"encrypt", nil, "加密文件", runEncrypt},
+	{"decrypt", nil, "解密文件", runDecrypt},
+	{"inspect-header", []string{"inspect"}, "查看加密文件头", runInspect},
+	{"rewrap", nil, "使用新的密钥重新加密", runRewrap},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "help" {
+		usage(stderr)
+		return exitUsage
+	}
+	for _, c := range commands {
+		if !c.match(args[0]) {
+			continue
+		}
+		err := c.run(args[1:], stdout, stderr)
+		if err == nil {
+			return exitOK
+		}
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintf(stderr, "aescrypt %s: %v\n", c.name, err)
+		}
+		return exitCode(err)
+	}
+	fmt.Fprintf(stderr, "aescrypt: unknown command %q\n", args[0])
+	usage(stderr)
+	return exitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: aescrypt <command> [flags]")
+	fmt.Fprintln(w)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-14s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "密钥和口令: env:NAME, file:PATH 或 prompt. 退出码: 0 成功, 1 错误, 2 参数错误, 3 密钥错误, 4 输出已存在")
+}
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, errUsage), errors.Is(err, errInvalidSpec):
+		return exitUsage
+	case errors.Is(err, aes.ErrAuth), errors.Is(err, aes.ErrMissingKey), errors.Is(err, aes.ErrIncorrectPassphrase),
+		errors.Is(err, aes.ErrPassphraseRequired), errors.Is(err, aes.ErrUnknownKeyID), errors.Is(err, aes.ErrNoIdentity),
+		errors.Is(err, rsa.ErrDecryption):
+		return exitAuth
+	case errors.Is(err, os.ErrExist):
+		return exitExists
+	default:
+		return exitError
+	}
+}
+
+// 解析参数, 出错或有多余参数时返回 errUsage
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected argument %q\n", fs.Arg(0))
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// 缺少必需参数
+func missing(fs *flag.FlagSet, name string) error {
+	fmt.Fprintf(fs.Output(), "-%s is required\n", name)
+	fs.Usage()
+	return errUsage
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/happyxhw/pkg/aes"
+)
+
+func runTest(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String() + stderr.String()
+}
+
+func requireRun(t *testing.T, args ...string) string {
+	t.Helper()
+	code, out := runTest(t, args...)
+	require.Equal(t, exitOK, code, out)
+	return out
+}
+
+func TestAESCrypt(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	data := make([]byte, 200*1024)
+	_, err := io.ReadFull(rand.Reader, data)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(in, data, 0600))
+
+	// aes 密钥
+	keyFile := filepath.Join(dir, "aes.key")
+	requireRun(t, "keygen", "-out", keyFile)
+	fi, err := os.Stat(keyFile)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	code, _ := runTest(t, "keygen", "-out", keyFile)
+	require.Equal(t, exitExists, code)
+	// -force 覆盖其他用户可读的已有文件时同样使用 0600
+	require.NoError(t, os.Chmod(keyFile, 0644))
+	requireRun(t, "keygen", "-out", keyFile, "-force")
+	fi, err = os.Stat(keyFile)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	enc, dec := filepath.Join(dir, "in.enc"), filepath.Join(dir, "dec")
+	requireRun(t, "encrypt", "-in", in, "-key", "file:"+keyFile)
+	requireRun(t, "decrypt", "-in", enc, "-out", dec, "-key", "file:"+keyFile)
+	requireFile(t, dec, data)
+	out := requireRun(t, "inspect-header", "-in", enc)
+	require.Contains(t, out, "kdf:         raw")
+
+	code, _ = runTest(t, "encrypt", "-in", in, "-key", "file:"+keyFile, "-no-overwrite")
+	require.Equal(t, exitExists, code)
+
+	// 密钥错误
+	other := filepath.Join(dir, "other.key")
+	requireRun(t, "keygen", "-out", other)
+	code, _ = runTest(t, "decrypt", "-in", enc, "-out", filepath.Join(dir, "bad"), "-key", "file:"+other)
+	require.Equal(t, exitAuth, code)
+	code, _ = runTest(t, "decrypt", "-in", enc, "-out", filepath.Join(dir, "bad"), "-key", "env:HX_TEST_NOT_SET")
+	require.Equal(t, exitAuth, code)
+	_, err = os.Stat(filepath.Join(dir, "bad"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// x25519, 文件头带原始路径
+	x := filepath.Join(dir, "x25519")
+	pub := requireRun(t, "keygen", "-type", "x25519", "-out", x)
+	require.NotEmpty(t, strings.TrimSpace(pub))
+	xEnc := filepath.Join(dir, "x.enc")
+	orig := filepath.Join(dir, "orig")
+	requireRun(t, "encrypt", "-in", in, "-out", xEnc, "-x25519-pub", x+".pub", "-path", "orig")
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	out = requireRun(t, "decrypt", "-in", xEnc, "-x25519-key", "file:"+x)
+	require.Equal(t, "orig", strings.TrimSpace(out))
+	requireFile(t, orig, data)
+
+	// 文件头中的绝对路径需要指定 -out
+	absEnc := filepath.Join(dir, "abs.enc")
+	requireRun(t, "encrypt", "-in", in, "-out", absEnc, "-x25519-pub", x+".pub", "-path", filepath.Join(dir, "abs"))
+	code, out = runTest(t, "decrypt", "-in", absEnc, "-x25519-key", "file:"+x)
+	require.Equal(t, exitError, code)
+	require.Contains(t, out, "unsafe path")
+	_, err = os.Stat(filepath.Join(dir, "abs"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// 重新加密为口令模式, 口令从环境变量读取
+	t.Setenv("HX_TEST_PASSWORD", "correct horse")
+	pwEnc := filepath.Join(dir, "pw.enc")
+	requireRun(t, "rewrap", "-in", xEnc, "-out", pwEnc, "-x25519-key", "file:"+x, "-new-password", "env:HX_TEST_PASSWORD", "-compress", "zstd")
+	out = requireRun(t, "inspect", "-in", pwEnc) // 旧的命令名
+	require.Contains(t, out, "kdf:         argon2id")
+	require.Contains(t, out, "compress:    zstd")
+	pwEnc2 := filepath.Join(dir, "pw2.enc")
+	requireRun(t, "rewrap", "-in", pwEnc, "-out", pwEnc2, "-password", "env:HX_TEST_PASSWORD", "-new-password", "env:HX_TEST_PASSWORD", "-no-overwrite")
+	code, _ = runTest(t, "rewrap", "-in", pwEnc, "-out", pwEnc2, "-password", "env:HX_TEST_PASSWORD", "-new-password", "env:HX_TEST_PASSWORD", "-no-overwrite")
+	require.Equal(t, exitExists, code)
+	require.NoError(t, os.Remove(orig))
+	requireRun(t, "decrypt", "-in", pwEnc, "-password", "env:HX_TEST_PASSWORD")
+	requireFile(t, orig, data)
+
+	t.Setenv("HX_TEST_PASSWORD", "wrong")
+	code, _ = runTest(t, "decrypt", "-in", pwEnc, "-out", filepath.Join(dir, "bad"), "-password", "env:HX_TEST_PASSWORD")
+	require.Equal(t, exitAuth, code)
+}
+
+func TestAESCryptRSA(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	data := bytes.Repeat([]byte("rsa"), 10000)
+	require.NoError(t, os.WriteFile(in, data, 0600))
+
+	keyKey := filepath.Join(dir, "keykey")
+	requireRun(t, "keygen", "-out", keyKey)
+	rsaKey := filepath.Join(dir, "id_rsa")
+	requireRun(t, "keygen", "-type", "rsa", "-bits", "2048", "-out", rsaKey, "-keykey", "file:"+keyKey)
+
+	enc := filepath.Join(dir, "in.enc")
+	requireRun(t, "encrypt", "-in", in, "-rsa-pub", rsaKey+".pub", "-keykey", "file:"+keyKey, "-oaep")
+	dec := filepath.Join(dir, "dec")
+	requireRun(t, "decrypt", "-in", enc, "-out", dec, "-rsa-key", rsaKey, "-keykey", "file:"+keyKey)
+	requireFile(t, dec, data)
+	require.Contains(t, requireRun(t, "inspect-header", "-in", enc), "kdf:         rsa-oaep")
+
+	// 口令加密的私钥, 从终端读取口令
+	defer func(f func(string) ([]byte, error)) { readPassword = f }(readPassword)
+	readPassword = func(string) ([]byte, error) { return []byte("secret"), nil }
+	pemKey := filepath.Join(dir, "pem")
+	requireRun(t, "keygen", "-type", "rsa", "-bits", "2048", "-out", pemKey, "-passphrase", "prompt")
+	enc2 := filepath.Join(dir, "in2.enc")
+	requireRun(t, "encrypt", "-in", in, "-out", enc2, "-rsa-pub", pemKey+".pub", "-keykey", "file:"+keyKey)
+	requireRun(t, "decrypt", "-in", enc2, "-out", filepath.Join(dir, "dec2"), "-rsa-key", pemKey, "-keykey", "file:"+keyKey)
+	requireFile(t, filepath.Join(dir, "dec2"), data)
+	readPassword = func(string) ([]byte, error) { return []byte("wrong"), nil }
+	code, _ := runTest(t, "decrypt", "-in", enc2, "-out", filepath.Join(dir, "dec3"), "-rsa-key", pemKey, "-keykey", "file:"+keyKey)
+	require.Equal(t, exitAuth, code)
+}
+
+func TestAESCryptUsage(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"unknown"},
+		{"encrypt"},
+		{"encrypt", "-in", "a"},
+		{"encrypt", "-in", "a", "-key", "env:A", "-password", "env:B"},
+		{"encrypt", "-in", "a", "-key", "A"},
+		{"decrypt", "-in", "a"},
+		{"keygen", "-out", "a", "-type", "dsa"},
+		{"inspect-header", "-in", "a", "extra"},
+		{"inspect", "-bad"},
+	} {
+		code, _ := runTest(t, args...)
+		require.Equal(t, exitUsage, code, args)
+	}
+	code, _ := runTest(t, "inspect", "-in", filepath.Join(t.TempDir(), "missing"))
+	require.Equal(t, exitError, code)
+}
+
+func requireFile(t *testing.T, path string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(want, got))
+}
+
+func TestRewrapNoOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	in, enc, out := filepath.Join(dir, "in"), filepath.Join(dir, "in.enc"), filepath.Join(dir, "out")
+	require.NoError(t, os.WriteFile(in, []byte("hello"), 0600))
+	key, err := aes.GenAesKey(32)
+	require.NoError(t, err)
+	require.NoError(t, aes.EncryptFileContext(context.Background(), in, enc, key))
+
+	// 检查之后被其他进程创建的输出也不会被覆盖
+	require.NoError(t, os.WriteFile(out, []byte("other"), 0600))
+	err = rewrap(enc, out, &aes.Keys{Key: key}, &encrypter{key: key}, aes.CodecNone, false, true)
+	require.ErrorIs(t, err, os.ErrExist)
+	require.Equal(t, exitExists, exitCode(err))
+	requireFile(t, out, []byte("other"))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/happyxhw/pkg/aes"
+)
+
+var (
+	// errInvalidSpec 密钥来源格式错误
+	errInvalidSpec = errors.New("secret must be env:NAME, file:PATH or prompt")
+	// errMismatch 两次输入的口令不一致
+	errMismatch = errors.New("passphrases do not match")
+	// errEmpty 口令为空
+	errEmpty = errors.New("empty passphrase")
+)
+
+// readPassword 从终端读取口令, 不回显, 提示输出到 stderr
+var readPassword = func(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("cannot prompt for %s: stdin is not a terminal", prompt)
+	}
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	defer fmt.Fprintln(os.Stderr)
+	return term.ReadPassword(fd)
+}
+
+// 读取 spec 指定的密钥或口令: env:NAME, file:PATH 或 prompt, confirm 时需要输入两次
+func loadSecret(spec, prompt string, confirm bool) ([]byte, error) {
+	switch {
+	case spec == "prompt":
+		p, err := readPassword(prompt)
+		if err != nil {
+			return nil, err
+		}
+		if confirm {
+			p2, err := readPassword("confirm " + prompt)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(p, p2) {
+				return nil, errMismatch
+			}
+		}
+		if len(p) == 0 {
+			return nil, errEmpty
+		}
+		return p, nil
+	case strings.HasPrefix(spec, "env:"):
+		name := strings.TrimPrefix(spec, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok || v == "" {
+			return nil, fmt.Errorf("env %s not set: %w", name, aes.ErrMissingKey)
+		}
+		return []byte(v), nil
+	case strings.HasPrefix(spec, "file:"):
+		return os.ReadFile(strings.TrimPrefix(spec, "file:"))
+	default:
+		return nil, fmt.Errorf("%w: %q", errInvalidSpec, spec)
+	}
+}
+
+// 读取口令, 去掉文件末尾的换行
+func loadPassword(spec, prompt string, confirm bool) ([]byte, error) {
+	p, err := loadSecret(spec, prompt, confirm)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(p, "\r\n"), nil
+}
+
+// 读取 base64 编码的 AES 密钥, 文件中也可以是 16, 24 或 32 字节的原始密钥
+func loadKey(spec, prompt string) ([]byte, error) {
+	data, err := loadSecret(spec, prompt, false)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		if strings.HasPrefix(spec, "file:") && validKeySize(len(data)) {
+			return data, nil
+		}
+		return nil, fmt.Errorf("%s: invalid base64 key", prompt)
+	}
+	if !validKeySize(len(key)) {
+		return nil, fmt.Errorf("%s: invalid key size %d", prompt, len(key))
+	}
+	return key, nil
+}
+
+func validKeySize(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
+
+// 读取文本格式的 keyring, 见 aes.ParseKeyring
+func loadKeyring(spec string) (*aes.Keyring, error) {
+	data, err := loadSecret(spec, "keyring", false)
+	if err != nil {
+		return nil, err
+	}
+	return aes.ParseKeyring(string(data))
+}
+
+func loadX25519Key(spec string) (aes.X25519PrivateKey, error) {
+	data, err := loadSecret(spec, "x25519 key", false)
+	if err != nil {
+		return nil, err
+	}
+	return aes.ParseX25519PrivateKey(string(bytes.TrimSpace(data)))
+}
+
+func loadX25519PublicKey(path string) (aes.X25519PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return aes.ParseX25519PublicKey(string(bytes.TrimSpace(data)))
+}
+
+// 读取 RSA 私钥. keyKey 用于 GenRsaPrivateKey 加密的文件, PEM 文件忽略 keyKey;
+// 私钥被口令加密且没有指定 passphrase 时从终端读取
+func loadRSAKey(path string, keyKey []byte, passphraseSpec string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		keyKey = nil
+	}
+	var passphrase []byte
+	if passphraseSpec != "" {
+		if passphrase, err = loadPassword(passphraseSpec, "passphrase", false); err != nil {
+			return nil, err
+		}
+	}
+	key, err := aes.ReadAnyPrivateKey(path, keyKey, passphrase)
+	if errors.Is(err, aes.ErrPassphraseRequired) && passphraseSpec == "" {
+		if passphrase, err = loadPassword("prompt", "passphrase for "+path, false); err != nil {
+			return nil, fmt.Errorf("%w: %v", aes.ErrPassphraseRequired, err)
+		}
+		key, err = aes.ReadAnyPrivateKey(path, keyKey, passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pk, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, aes.ErrUnsupportedKey
+	}
+	return pk, nil
+}
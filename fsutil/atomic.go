@@ -0,0 +1,121 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+/*
+原子写入: 先写入同目录下以 . 开头的临时文件, Commit 时 fsync 后改名为目标文件并 fsync 目录,
+未 Commit 时 Close 删除临时文件. 失败时不会留下不完整的输出, 也不会破坏已有的文件.
+*/
+
+const defaultAtomicPerm os.FileMode = 0644
+
+// AtomicOpt CreateAtomic 的可选参数
+type AtomicOpt struct {
+	Perm        os.FileMode // 目标文件的权限, 默认 0644, 覆盖已有文件时同样生效
+	NoOverwrite bool        // 目标文件已存在时返回 os.ErrExist, Commit 时使用 link 避免覆盖并发创建的文件
+}
+
+func getAtomicOpt(opts ...AtomicOpt) AtomicOpt {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return AtomicOpt{}
+}
+
+// AtomicFile 写入临时文件, Commit 后才出现在目标路径
+type AtomicFile struct {
+	*os.File
+	path        string
+	noOverwrite bool
+	done        bool
+}
+
+// CreateAtomic 在 path 的目录下创建临时文件
+func CreateAtomic(path string, opts ...AtomicOpt) (*AtomicFile, error) {
+	opt := getAtomicOpt(opts...)
+	if opt.NoOverwrite {
+		if _, err := os.Lstat(path); err == nil {
+			return nil, &os.PathError{Op: "create", Path: path, Err: os.ErrExist}
+		}
+	}
+	perm := opt.Perm
+	if perm == 0 {
+		perm = defaultAtomicPerm
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Chmod(perm); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+	return &AtomicFile{File: f, path: path, noOverwrite: opt.NoOverwrite}, nil
+}
+
+// Commit fsync 后把临时文件改名为目标文件, 失败时删除临时文件
+func (f *AtomicFile) Commit() error {
+	f.done = true
+	tmp := f.File.Name()
+	err := f.File.Sync()
+	if err2 := f.File.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		if f.noOverwrite {
+			// link 在目标存在时失败, 避免检查和改名之间被其他进程创建
+			if err = os.Link(tmp, f.path); err == nil {
+				err = os.Remove(tmp)
+			}
+		} else {
+			err = os.Rename(tmp, f.path)
+		}
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(f.path))
+}
+
+// Close 未 Commit 时删除临时文件
+func (f *AtomicFile) Close() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	err := f.File.Close()
+	_ = os.Remove(f.File.Name())
+	return err
+}
+
+// WriteFileAtomic 原子写入 data
+func WriteFileAtomic(path string, data []byte, opts ...AtomicOpt) error {
+	f, err := CreateAtomic(path, opts...)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	return f.Commit()
+}
+
+// 改名后 fsync 目录, 保证断电后目录项存在, windows 不支持
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
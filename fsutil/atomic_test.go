@@ -0,0 +1,49 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAtomicFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out")
+	require.NoError(t, os.WriteFile(path, []byte("old"), 0644))
+
+	// 未 Commit 时不影响已有文件, 也不留下临时文件
+	f, err := CreateAtomic(path, AtomicOpt{Perm: 0600})
+	require.NoError(t, err)
+	_, err = f.Write([]byte("new"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	requireFile(t, path, []byte("old"))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// 覆盖已有文件时使用新的权限
+	require.NoError(t, WriteFileAtomic(path, []byte("new"), AtomicOpt{Perm: 0600}))
+	requireFile(t, path, []byte("new"))
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	err = WriteFileAtomic(path, []byte("other"), AtomicOpt{NoOverwrite: true})
+	require.ErrorIs(t, err, os.ErrExist)
+	requireFile(t, path, []byte("new"))
+
+	// Commit 前目标被创建时不覆盖
+	path2 := filepath.Join(dir, "out2")
+	f, err = CreateAtomic(path2, AtomicOpt{NoOverwrite: true})
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, os.WriteFile(path2, []byte("racer"), 0644))
+	require.ErrorIs(t, f.Commit(), os.ErrExist)
+	requireFile(t, path2, []byte("racer"))
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
@@ -0,0 +1,168 @@
+package fsutil
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+/*
+按内容切分(FastCDC): 使用 gear 滚动哈希寻找切分点, 插入或删除数据只影响附近的分片, 不同版本的文件之间可以去重.
+	跳过前 Min 字节, 在 Avg 之前使用更严格的 maskS, 之后使用更宽松的 maskL(normalized chunking),
+	到 Max 时强制切分, 分片大小在 [Min, Max] 之间, 平均约为 Avg.
+gear 表由固定种子生成, 修改会使所有分片位置变化. 内存占用为一个 Max 大小的缓冲区.
+*/
+
+// ErrInvalidCDCOpt 按内容切分的参数错误
+var ErrInvalidCDCOpt = errors.New("invalid cdc options")
+
+const minCDCAvg = 64
+
+// CDCOpt 按内容切分的参数, 需要满足 0 < Min <= Avg <= Max, Avg 为不小于 64 的 2 的幂
+type CDCOpt struct {
+	Min int64
+	Avg int64
+	Max int64
+}
+
+// NewCDCOpt Min 为 avg/4, Max 为 avg*4
+func NewCDCOpt(avg int64) CDCOpt {
+	return CDCOpt{Min: avg / 4, Avg: avg, Max: avg * 4}
+}
+
+func (o *CDCOpt) validate() error {
+	if o.Min <= 0 || o.Avg < minCDCAvg || o.Avg&(o.Avg-1) != 0 || o.Min > o.Avg || o.Avg > o.Max || o.Max > 1<<30 {
+		return ErrInvalidCDCOpt
+	}
+	return nil
+}
+
+// gear 表, splitmix64 生成
+var gear = func() (t [256]uint64) {
+	x := uint64(0x6a09e667f3bcc908)
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return t
+}()
+
+// 高 n 位为 1, 左移的 gear 哈希高位与更多的字节有关
+func topMask(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+type chunker struct {
+	opt          CDCOpt
+	maskS, maskL uint64
+}
+
+func newChunker(opt CDCOpt) *chunker {
+	b := bits.Len64(uint64(opt.Avg)) - 1
+	return &chunker{opt: opt, maskS: topMask(b + 2), maskL: topMask(b - 2)}
+}
+
+// 返回 data 中第一个分片的长度, data 不足 Max 时认为是文件末尾
+func (c *chunker) cut(data []byte) int {
+	n := len(data)
+	if int64(n) <= c.opt.Min {
+		return n
+	}
+	if int64(n) > c.opt.Max {
+		n = int(c.opt.Max)
+	}
+	normal := int(c.opt.Avg)
+	if n < normal {
+		normal = n
+	}
+	var fp uint64
+	i := int(c.opt.Min)
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// SplitCDC 按内容切分 in, 返回的 File 与 Split 相同, SplitSize 为 0, CDC 为使用的参数
+func SplitCDC(in string, opt CDCOpt) (*File, error) {
+	return SplitCDCContext(context.Background(), in, opt, nil)
+}
+
+// SplitCDCContext 同 SplitCDC, ctx 取消时返回 ctx.Err(), progress 可以为空
+func SplitCDCContext(ctx context.Context, in string, opt CDCOpt, progress ProgressFunc) (*File, error) {
+	if err := opt.validate(); err != nil {
+		return nil, err
+	}
+	inFile, file, err := openFile(in)
+	if err != nil {
+		return nil, err
+	}
+	defer inFile.Close()
+	file.CDC = &opt
+
+	r := io.LimitReader(NewProgress(ctx, file.Size, progress).Reader(inFile), file.Size)
+	if err = splitCDC(r, file, newChunker(opt)); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func splitCDC(r io.Reader, file *File, c *chunker) error {
+	buf := make([]byte, c.opt.Max)
+	md5File := md5.New() //nolint:gosec
+	var n int
+	var offset int64
+	eof := false
+	for {
+		if !eof && n < len(buf) {
+			m, err := io.ReadFull(r, buf[n:])
+			n += m
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if n == 0 {
+			break
+		}
+		size := c.cut(buf[:n])
+		chunk := buf[:size]
+		_, _ = md5File.Write(chunk)
+		md5Sum, shaSum := md5.Sum(chunk), sha256.Sum256(chunk) //nolint:gosec
+		file.Parts = append(file.Parts, &Part{
+			MD5:    hex.EncodeToString(md5Sum[:]),
+			SHA256: hex.EncodeToString(shaSum[:]),
+			Size:   int64(size),
+			Offset: offset,
+		})
+		offset += int64(size)
+		n = copy(buf, buf[size:n])
+	}
+	if offset != file.Size {
+		return io.ErrUnexpectedEOF
+	}
+	file.MD5 = hex.EncodeToString(md5File.Sum(nil))
+	// 空文件也有一个分片, 与 Split 一致
+	if len(file.Parts) == 0 {
+		shaSum := sha256.Sum256(nil)
+		file.Parts = append(file.Parts, &Part{MD5: file.MD5, SHA256: hex.EncodeToString(shaSum[:])})
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitCDC(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	data := writeTestFile(t, in, 2<<20)
+	opt := NewCDCOpt(16 * 1024)
+
+	f, err := SplitCDC(in, opt)
+	require.NoError(t, err)
+	require.NoError(t, f.Validate())
+	require.Zero(t, f.SplitSize)
+	require.Equal(t, &opt, f.CDC)
+	md5File, err := GetFileMD5(in)
+	require.NoError(t, err)
+	require.Equal(t, md5File, f.MD5)
+	for i, p := range f.Parts {
+		require.LessOrEqual(t, p.Size, opt.Max)
+		if i < len(f.Parts)-1 {
+			require.GreaterOrEqual(t, p.Size, opt.Min)
+		}
+	}
+	// 平均大小接近 Avg
+	avg := f.Size / int64(len(f.Parts))
+	require.Greater(t, avg, opt.Avg/2)
+	require.Less(t, avg, opt.Avg*2)
+
+	// 切分点只与内容有关
+	f2, err := SplitCDC(in, opt)
+	require.NoError(t, err)
+	require.Equal(t, f.Parts, f2.Parts)
+
+	// 开头插入一个字节, 之后的分片大部分不变
+	data2 := append([]byte{data[0], 'x'}, data[1:]...)
+	require.NoError(t, os.WriteFile(in, data2, 0600))
+	f2, err = SplitCDC(in, opt)
+	require.NoError(t, err)
+	seen := make(map[string]bool)
+	for _, p := range f.Parts {
+		seen[p.SHA256] = true
+	}
+	var same int
+	for _, p := range f2.Parts {
+		if seen[p.SHA256] {
+			same++
+		}
+	}
+	require.GreaterOrEqual(t, same, len(f2.Parts)-2)
+
+	// 作为对比, 固定大小切分在插入后所有分片都变化
+	shifted, err := Split(in, opt.Avg)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(in, data, 0600))
+	fixed, err := Split(in, opt.Avg)
+	require.NoError(t, err)
+	for i := range fixed.Parts {
+		require.NotEqual(t, fixed.Parts[i].SHA256, shifted.Parts[i].SHA256)
+	}
+}
+
+func TestSplitCDCSmall(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	opt := NewCDCOpt(1024)
+	for _, size := range []int{0, 1, int(opt.Min), int(opt.Max) + 1} {
+		data := writeTestFile(t, in, size)
+		f, err := SplitCDC(in, opt)
+		require.NoError(t, err)
+		require.NoError(t, f.Validate())
+		require.Equal(t, int64(len(data)), f.Size)
+		require.NotEmpty(t, f.Parts[0].SHA256)
+	}
+}
+
+func TestCDCOptInvalid(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeTestFile(t, in, 1024)
+	for _, opt := range []CDCOpt{
+		{Min: 2048, Avg: 1024, Max: 4096}, // Min > Avg
+		{Min: 256, Avg: 4096, Max: 1024},  // Avg > Max
+		{Min: 256, Avg: 1000, Max: 4096},  // Avg 不是 2 的幂
+		{Min: 8, Avg: 32, Max: 128},       // Avg 太小
+		{Min: 0, Avg: 1024, Max: 4096},
+		{Min: 256, Avg: 1024, Max: 2 << 30},
+		NewCDCOpt(3000),
+	} {
+		_, err := SplitCDC(in, opt)
+		require.ErrorIs(t, err, ErrInvalidCDCOpt, opt)
+	}
+}
@@ -0,0 +1,230 @@
+package fsutil
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidManifest manifest 中的分片不连续或大小与文件不一致
+	ErrInvalidManifest = errors.New("invalid manifest")
+	// ErrPartMismatch 分片缺失或损坏, 详细信息见 JoinError
+	ErrPartMismatch = errors.New("parts missing or corrupt")
+	// ErrMD5Mismatch 合并后的文件 md5 与 manifest 不一致
+	ErrMD5Mismatch = errors.New("md5 mismatch")
+)
+
+// JoinError 缺失或损坏的分片序号, 从 0 开始
+type JoinError struct {
+	Missing []int
+	Corrupt []int
+}
+
+func (e *JoinError) Error() string {
+	var s []string
+	if len(e.Missing) > 0 {
+		s = append(s, fmt.Sprintf("missing parts %v", e.Missing))
+	}
+	if len(e.Corrupt) > 0 {
+		s = append(s, fmt.Sprintf("corrupt parts %v", e.Corrupt))
+	}
+	return strings.Join(s, ", ")
+}
+
+func (e *JoinError) Unwrap() error {
+	return ErrPartMismatch
+}
+
+// ReadManifest 读取 SplitToDir 写入的 manifest
+func ReadManifest(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// JoinDir 读取 dir 中 SplitToDir 写入的 manifest 和分片, 合并到 out
+func JoinDir(dir, out string) (*File, error) {
+	f, err := ReadManifest(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return nil, err
+	}
+	parts, err := filepath.Glob(filepath.Join(dir, "part-*"))
+	if err != nil {
+		return nil, err
+	}
+	return f, Join(f, parts, out)
+}
+
+// Join 按 manifest 校验分片(大小, md5, 以及 sha256 如有)后合并到 out, 并校验整个文件的 md5.
+// parts 为分片文件路径, 顺序任意: 文件名为 PartName 时按序号对应, 否则按大小和 md5 对应.
+// 分片缺失或损坏时返回 *JoinError, 失败时不会产生 out
+func Join(f *File, parts []string, out string) error {
+	return JoinContext(context.Background(), f, parts, out, nil)
+}
+
+// JoinContext 同 Join, ctx 取消时返回 ctx.Err(), progress 可以为空.
+// 校验和合并各读取一遍分片, progress 的 total 为 2 * f.Size
+func JoinContext(ctx context.Context, f *File, parts []string, out string, progress ProgressFunc) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	p := NewProgress(ctx, 2*f.Size, progress)
+	paths, err := matchParts(p, f, parts)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := CreateAtomic(out)
+	if err != nil {
+		return err
+	}
+	defer tmp.Close()
+	md5File := md5.New() //nolint:gosec
+	w := io.MultiWriter(tmp, md5File)
+	for i, path := range paths {
+		if err = copyPart(p, w, path, f.Parts[i].Size); err != nil {
+			return err
+		}
+	}
+	if hex.EncodeToString(md5File.Sum(nil)) != f.MD5 {
+		return ErrMD5Mismatch
+	}
+	if !f.ModifyTime.IsZero() {
+		if err = os.Chtimes(tmp.Name(), f.ModifyTime, f.ModifyTime); err != nil {
+			return err
+		}
+	}
+	return tmp.Commit()
+}
+
+// Validate 检查分片从 0 开始连续, 大小之和等于文件大小
+func (f *File) Validate() error {
+	if len(f.Parts) == 0 {
+		return ErrInvalidManifest
+	}
+	var offset int64
+	for _, p := range f.Parts {
+		if p == nil || p.Offset != offset || p.Size < 0 {
+			return ErrInvalidManifest
+		}
+		offset += p.Size
+	}
+	if offset != f.Size {
+		return ErrInvalidManifest
+	}
+	return nil
+}
+
+// 返回按序号排列的分片路径, 每个分片都经过大小和哈希校验
+func matchParts(p *Progress, f *File, parts []string) ([]string, error) {
+	paths := make([]string, len(f.Parts))
+	corrupt := make(map[int]bool)
+	for _, path := range parts {
+		h, err := hashPart(p, path)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := parsePartName(filepath.Base(path)); ok && i < len(f.Parts) {
+			if paths[i] != "" {
+				continue
+			}
+			if h.match(f.Parts[i]) {
+				paths[i] = path
+			} else {
+				corrupt[i] = true
+			}
+			continue
+		}
+		for i, part := range f.Parts {
+			if paths[i] == "" && h.match(part) {
+				paths[i] = path
+				break
+			}
+		}
+	}
+
+	var e JoinError
+	for i, path := range paths {
+		switch {
+		case path != "":
+		case corrupt[i]:
+			e.Corrupt = append(e.Corrupt, i)
+		default:
+			e.Missing = append(e.Missing, i)
+		}
+	}
+	if len(e.Missing) > 0 || len(e.Corrupt) > 0 {
+		sort.Ints(e.Corrupt)
+		return nil, &e
+	}
+	return paths, nil
+}
+
+func parsePartName(name string) (int, bool) {
+	s := strings.TrimPrefix(name, "part-")
+	if s == name {
+		return 0, false
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 || PartName(i) != name {
+		return 0, false
+	}
+	return i, true
+}
+
+type partHash struct {
+	md5, sha256 string
+	size        int64
+}
+
+// 旧的 manifest 没有 sha256, 只比较大小和 md5
+func (h *partHash) match(p *Part) bool {
+	return h.size == p.Size && h.md5 == p.MD5 && (p.SHA256 == "" || h.sha256 == p.SHA256)
+}
+
+func hashPart(p *Progress, path string) (*partHash, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	md5h, shah := md5.New(), sha256.New() //nolint:gosec
+	n, err := io.Copy(io.MultiWriter(md5h, shah), p.Reader(f))
+	if err != nil {
+		return nil, err
+	}
+	return &partHash{md5: hex.EncodeToString(md5h.Sum(nil)), sha256: hex.EncodeToString(shah.Sum(nil)), size: n}, nil
+}
+
+func copyPart(p *Progress, w io.Writer, path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := io.Copy(w, p.Reader(f))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return ErrMD5Mismatch
+	}
+	return nil
+}
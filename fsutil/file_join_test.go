@@ -0,0 +1,118 @@
+package fsutil
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestFile(t *testing.T, path string, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	_, err := io.ReadFull(rand.Reader, data)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	return data
+}
+
+func requireFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+}
+
+func TestJoinDir(t *testing.T) {
+	dir := t.TempDir()
+	in, parts, out := filepath.Join(dir, "in"), filepath.Join(dir, "parts"), filepath.Join(dir, "out")
+	data := writeTestFile(t, in, 10*1024+7)
+
+	f, err := SplitToDir(in, parts, 1024)
+	require.NoError(t, err)
+	require.Len(t, f.Parts, 11)
+	m, err := ReadManifest(filepath.Join(parts, ManifestName))
+	require.NoError(t, err)
+	require.Equal(t, f.MD5, m.MD5)
+
+	got, err := JoinDir(parts, out)
+	require.NoError(t, err)
+	require.Equal(t, f.MD5, got.MD5)
+	requireFile(t, out, data)
+
+	// 文件名不是 PartName 时按内容对应
+	names := make([]string, len(f.Parts))
+	for i := range f.Parts {
+		names[len(names)-1-i] = filepath.Join(dir, "renamed-"+PartName(i))
+		require.NoError(t, os.Rename(filepath.Join(parts, PartName(i)), names[len(names)-1-i]))
+	}
+	require.NoError(t, os.Remove(out))
+	require.NoError(t, Join(f, names, out))
+	requireFile(t, out, data)
+}
+
+func TestJoinError(t *testing.T) {
+	dir := t.TempDir()
+	in, parts, out := filepath.Join(dir, "in"), filepath.Join(dir, "parts"), filepath.Join(dir, "out")
+	writeTestFile(t, in, 5*1024)
+	_, err := SplitToDir(in, parts, 1024)
+	require.NoError(t, err)
+
+	// 分片缺失
+	require.NoError(t, os.Remove(filepath.Join(parts, PartName(2))))
+	_, err = JoinDir(parts, out)
+	var je *JoinError
+	require.ErrorAs(t, err, &je)
+	require.ErrorIs(t, err, ErrPartMismatch)
+	require.Equal(t, []int{2}, je.Missing)
+	require.Empty(t, je.Corrupt)
+
+	// 分片损坏
+	_, err = SplitToDir(in, parts, 1024)
+	require.NoError(t, err)
+	p := filepath.Join(parts, PartName(3))
+	b, err := os.ReadFile(p)
+	require.NoError(t, err)
+	b[100] ^= 1
+	require.NoError(t, os.WriteFile(p, b, 0600))
+	_, err = JoinDir(parts, out)
+	require.ErrorAs(t, err, &je)
+	require.Empty(t, je.Missing)
+	require.Equal(t, []int{3}, je.Corrupt)
+	_, err = os.Stat(out)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestJoinMD5Mismatch(t *testing.T) {
+	dir := t.TempDir()
+	in, parts, out := filepath.Join(dir, "in"), filepath.Join(dir, "parts"), filepath.Join(dir, "out")
+	writeTestFile(t, in, 3000)
+	f, err := SplitToDir(in, parts, 1024)
+	require.NoError(t, err)
+
+	f.MD5 = "00000000000000000000000000000000"
+	data, err := json.Marshal(f)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(parts, ManifestName), data, 0600))
+	_, err = JoinDir(parts, out)
+	require.ErrorIs(t, err, ErrMD5Mismatch)
+	_, err = os.Stat(out)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestJoinEmpty(t *testing.T) {
+	dir := t.TempDir()
+	in, parts, out := filepath.Join(dir, "in"), filepath.Join(dir, "parts"), filepath.Join(dir, "out")
+	require.NoError(t, os.WriteFile(in, nil, 0600))
+
+	f, err := SplitToDir(in, parts, 1024)
+	require.NoError(t, err)
+	require.Len(t, f.Parts, 1)
+	_, err = JoinDir(parts, out)
+	require.NoError(t, err)
+	requireFile(t, out, []byte{})
+}
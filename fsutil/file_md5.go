@@ -1,6 +1,7 @@
 package fsutil
 
 import (
+	"context"
 	"crypto/md5" //nolint:gosec
 	"encoding/hex"
 	"io"
@@ -8,14 +9,23 @@ import (
 )
 
 func GetFileMD5(in string) (string, error) {
+	return GetFileMD5Context(context.Background(), in, nil)
+}
+
+// GetFileMD5Context 同 GetFileMD5, ctx 取消时返回 ctx.Err(), progress 可以为空
+func GetFileMD5Context(ctx context.Context, in string, progress ProgressFunc) (string, error) {
 	inFile, err := os.Open(in)
 	if err != nil {
 		return "", err
 	}
 	defer inFile.Close()
 
+	fi, err := inFile.Stat()
+	if err != nil {
+		return "", err
+	}
 	md5h := md5.New() //nolint:gosec
-	_, err = io.Copy(md5h, inFile)
+	_, err = io.Copy(md5h, NewProgress(ctx, fi.Size(), progress).Reader(inFile))
 	if err != nil {
 		return "", err
 	}
@@ -1,76 +1,293 @@
 package fsutil
 
 import (
+	"context"
 	"crypto/md5" //nolint:gosec
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/happyxhw/pkg/util"
 )
 
+/*
+切分: 按 SplitSize 把文件分为若干分片(最后一个可能较小, 空文件也有一个分片), 计算每个分片和整个文件的 md5,
+以及每个分片的 sha256. 按内容切分见 file_cdc.go.
+默认顺序读取一遍, 分片和整个文件的 md5 同时计算, 内存占用固定为一个 splitBufLen 的缓冲区, 与 SplitSize 无关.
+SplitOpt.Workers 大于 1 时各分片使用 ReadAt 并发计算, 整个文件的 md5 由另一个 goroutine 顺序计算,
+文件会被读取两遍, 适合 SSD 或已在缓存中的文件.
+*/
+
+// ManifestName SplitToDir 写入的 manifest 文件名
+const ManifestName = "manifest.json"
+
+const splitBufLen = 256 * 1024
+
+// ErrInvalidSplitSize 分片大小必须大于 0
+var ErrInvalidSplitSize = errors.New("invalid split size")
+
 type File struct {
 	Path       string
 	MD5        string
 	Size       int64
-	SplitSize  int64
+	SplitSize  int64 // 按内容切分时为 0
 	ModifyTime time.Time
 	Parts      []*Part
+	CDC        *CDCOpt // 按内容切分的参数, 固定大小切分时为空
 }
 
 type Part struct {
 	MD5    string
+	SHA256 string // 旧的 manifest 可能为空
 	Size   int64
 	Offset int64
 }
 
-func Split(in string, splitSize int64) (*File, error) {
-	fi, err := os.Stat(in)
+// SplitOpt Split 的可选参数
+type SplitOpt struct {
+	Workers int // 大于 1 时使用 ReadAt 并发计算分片的 md5, 每个 worker 占用一个缓冲区
+}
+
+func getSplitOpt(opts ...SplitOpt) SplitOpt {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return SplitOpt{}
+}
+
+func Split(in string, splitSize int64, opts ...SplitOpt) (*File, error) {
+	return SplitContext(context.Background(), in, splitSize, nil, opts...)
+}
+
+// SplitContext 同 Split, ctx 取消时返回 ctx.Err(), progress 可以为空
+func SplitContext(ctx context.Context, in string, splitSize int64, progress ProgressFunc, opts ...SplitOpt) (*File, error) {
+	inFile, file, err := openSplit(in, splitSize)
 	if err != nil {
 		return nil, err
 	}
-	var file File
-	file.Path = in
-	file.Size = fi.Size()
+	defer inFile.Close()
+
+	p := NewProgress(ctx, file.Size, progress)
+	if opt := getSplitOpt(opts...); opt.Workers > 1 && len(file.Parts) > 1 {
+		err = splitParallel(p, inFile, file, opt.Workers)
+	} else {
+		err = splitSequential(p.Reader(inFile), file, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// 打开文件, 按文件大小生成分片的偏移和大小
+func openSplit(in string, splitSize int64) (*os.File, *File, error) {
+	if splitSize <= 0 {
+		return nil, nil, ErrInvalidSplitSize
+	}
+	inFile, file, err := openFile(in)
+	if err != nil {
+		return nil, nil, err
+	}
 	file.SplitSize = splitSize
-	file.ModifyTime = fi.ModTime()
-	if fi.Size() <= splitSize {
-		file.MD5, err = GetFileMD5(in)
-		if err != nil {
-			return nil, err
+	for offset := int64(0); offset < file.Size || len(file.Parts) == 0; offset += splitSize {
+		size := file.Size - offset
+		if size > splitSize {
+			size = splitSize
 		}
-		file.Parts = append(file.Parts, &Part{
-			MD5:  file.MD5,
-			Size: file.Size,
-		})
-		return &file, nil
+		file.Parts = append(file.Parts, &Part{Size: size, Offset: offset})
 	}
+	return inFile, file, nil
+}
 
+// 打开文件, 读取大小和修改时间
+func openFile(in string) (*os.File, *File, error) {
 	inFile, err := os.Open(in)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer inFile.Close()
-	var offset int64
+	fi, err := inFile.Stat()
+	if err != nil {
+		inFile.Close()
+		return nil, nil, err
+	}
+	return inFile, &File{Path: in, Size: fi.Size(), ModifyTime: fi.ModTime()}, nil
+}
+
+// 顺序读取一遍, 同时计算分片和整个文件的 md5, create 不为空时分片数据同时写入 create(i), 完成后 Commit
+func splitSequential(r io.Reader, file *File, create func(i int) (*AtomicFile, error)) error {
+	buf := make([]byte, splitBufLen)
 	md5File := md5.New() //nolint:gosec
-	for {
-		buf := make([]byte, splitSize)
-		n, err := inFile.Read(buf)
-		if err == io.EOF {
-			break
+	for i, part := range file.Parts {
+		// 只有一个分片时与整个文件的 md5 相同, 不必重复计算
+		var md5Part hash.Hash
+		shaPart := sha256.New()
+		w := io.MultiWriter(md5File, shaPart)
+		if len(file.Parts) > 1 {
+			md5Part = md5.New() //nolint:gosec
+			w = io.MultiWriter(md5File, md5Part, shaPart)
+		}
+		var pw *AtomicFile
+		if create != nil {
+			var err error
+			if pw, err = create(i); err != nil {
+				return err
+			}
+			w = io.MultiWriter(w, pw)
 		}
-		var p Part
-		p.Offset = offset
-		offset += int64(n)
-		if n < int(splitSize) {
-			buf = buf[:n]
+		err := copyFull(w, r, part.Size, buf)
+		if pw != nil {
+			if err == nil {
+				err = pw.Commit()
+			}
+			_ = pw.Close()
+		}
+		if err != nil {
+			return err
 		}
-		p.Size = int64(len(buf))
-		p.MD5 = util.Md5FromBytes(buf)
-		file.Parts = append(file.Parts, &p)
-		_, _ = md5File.Write(buf)
+		if md5Part != nil {
+			part.MD5 = hex.EncodeToString(md5Part.Sum(nil))
+		}
+		part.SHA256 = hex.EncodeToString(shaPart.Sum(nil))
 	}
 	file.MD5 = hex.EncodeToString(md5File.Sum(nil))
-	return &file, nil
+	if len(file.Parts) == 1 {
+		file.Parts[0].MD5 = file.MD5
+	}
+	return nil
+}
+
+// 分片使用 ReadAt 并发计算, 整个文件的 md5 同时顺序计算, 进度只统计分片
+func splitParallel(p *Progress, f *os.File, file *File, workers int) error {
+	ctx, cancel := context.WithCancel(p.ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		next     int64 = -1
+	)
+	setErr := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		md5File := md5.New() //nolint:gosec
+		r := NewProgress(ctx, file.Size, nil).Reader(io.NewSectionReader(f, 0, file.Size))
+		if err := copyFull(md5File, r, file.Size, make([]byte, splitBufLen)); err != nil {
+			setErr(err)
+			return
+		}
+		file.MD5 = hex.EncodeToString(md5File.Sum(nil))
+	}()
+
+	pp := NewProgress(ctx, p.total, p.fn)
+	if workers > len(file.Parts) {
+		workers = len(file.Parts)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, splitBufLen)
+			for ctx.Err() == nil {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(file.Parts) {
+					return
+				}
+				part := file.Parts[i]
+				md5Part, shaPart := md5.New(), sha256.New() //nolint:gosec
+				r := pp.Reader(io.NewSectionReader(f, part.Offset, part.Size))
+				if err := copyFull(io.MultiWriter(md5Part, shaPart), r, part.Size, buf); err != nil {
+					setErr(err)
+					return
+				}
+				part.MD5 = hex.EncodeToString(md5Part.Sum(nil))
+				part.SHA256 = hex.EncodeToString(shaPart.Sum(nil))
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	// 没有出错但 ctx 被取消
+	return p.Err()
+}
+
+// 从 r 复制 size 字节到 w, 数据不足时返回 io.ErrUnexpectedEOF
+func copyFull(w io.Writer, r io.Reader, size int64, buf []byte) error {
+	n, err := io.CopyBuffer(w, io.LimitReader(r, size), buf)
+	if err != nil {
+		return err
+	}
+	if n < size {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// PartName 第 i 个分片的文件名, 从 0 开始
+func PartName(i int) string {
+	return fmt.Sprintf("part-%06d", i)
+}
+
+// SplitToDir 切分 in, 第 i 个分片写入 dir/PartName(i), 最后写入 dir/ManifestName(File 的 json)
+func SplitToDir(in, dir string, splitSize int64) (*File, error) {
+	return SplitToDirContext(context.Background(), in, dir, splitSize, nil)
+}
+
+// SplitToDirContext 同 SplitToDir, ctx 取消时返回 ctx.Err(), progress 可以为空.
+// 分片和 manifest 先写入临时文件再改名, 失败时删除已经写入的分片
+func SplitToDirContext(ctx context.Context, in, dir string, splitSize int64, progress ProgressFunc) (*File, error) {
+	inFile, file, err := openSplit(in, splitSize)
+	if err != nil {
+		return nil, err
+	}
+	defer inFile.Close()
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var parts []string
+	ok := false
+	defer func() {
+		if !ok {
+			for _, p := range parts {
+				_ = os.Remove(p)
+			}
+		}
+	}()
+	create := func(i int) (*AtomicFile, error) {
+		path := filepath.Join(dir, PartName(i))
+		parts = append(parts, path)
+		return CreateAtomic(path)
+	}
+	if err = splitSequential(NewProgress(ctx, file.Size, progress).Reader(inFile), file, create); err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err = WriteFileAtomic(filepath.Join(dir, ManifestName), data); err != nil {
+		return nil, err
+	}
+	ok = true
+	return file, nil
 }
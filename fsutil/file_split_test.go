@@ -0,0 +1,97 @@
+package fsutil
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplit(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	const splitSize = 1024
+	for _, size := range []int{0, 1, splitSize - 1, splitSize, splitSize + 1, 10*splitSize + 7, splitBufLen + 3} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			data := writeTestFile(t, in, size)
+			md5File, err := GetFileMD5(in)
+			require.NoError(t, err)
+
+			seq, err := Split(in, splitSize)
+			require.NoError(t, err)
+			require.Equal(t, md5File, seq.MD5)
+			require.Equal(t, int64(size), seq.Size)
+			require.NoError(t, seq.Validate())
+			for _, p := range seq.Parts {
+				chunk := data[p.Offset : p.Offset+p.Size]
+				md5Sum, shaSum := md5.Sum(chunk), sha256.Sum256(chunk) //nolint:gosec
+				require.Equal(t, hex.EncodeToString(md5Sum[:]), p.MD5)
+				require.Equal(t, hex.EncodeToString(shaSum[:]), p.SHA256)
+			}
+
+			for _, workers := range []int{2, 3, 8} {
+				par, err := Split(in, splitSize, SplitOpt{Workers: workers})
+				require.NoError(t, err)
+				require.Equal(t, seq, par)
+			}
+		})
+	}
+
+	_, err := Split(in, 0)
+	require.ErrorIs(t, err, ErrInvalidSplitSize)
+}
+
+func TestSplitContext(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	writeTestFile(t, in, 100*1024+1)
+
+	for _, workers := range []int{1, 4} {
+		opt := SplitOpt{Workers: workers}
+		var done, total int64
+		_, err := SplitContext(context.Background(), in, 4096, func(d, t int64) { done, total = d, t }, opt)
+		require.NoError(t, err)
+		require.Equal(t, int64(100*1024+1), total)
+		require.Equal(t, total, done)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = SplitContext(ctx, in, 4096, nil, opt)
+		require.ErrorIs(t, err, context.Canceled)
+
+		// 进行中取消
+		ctx, cancel = context.WithCancel(context.Background())
+		_, err = SplitContext(ctx, in, 4096, func(d, t int64) {
+			if d >= t/2 {
+				cancel()
+			}
+		}, opt)
+		cancel()
+		require.ErrorIs(t, err, context.Canceled)
+	}
+}
+
+func TestSplitToDirCancel(t *testing.T) {
+	dir := t.TempDir()
+	in, parts := filepath.Join(dir, "in"), filepath.Join(dir, "parts")
+	writeTestFile(t, in, 10*1024)
+
+	// 写入 3 个分片后取消, 已经写入的分片和临时文件都被删除
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err := SplitToDirContext(ctx, in, parts, 1024, func(done, total int64) {
+		if done >= 3*1024 {
+			cancel()
+		}
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	entries, err := os.ReadDir(parts)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
@@ -0,0 +1,83 @@
+package fsutil
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// ProgressFunc 进度回调, done 为已处理的字节数, total 为总字节数
+type ProgressFunc func(done, total int64)
+
+// Progress 累计多个 reader 的进度, 并发安全, 回调不会被并发调用.
+// 每次读取前检查 ctx, 取消后返回 ctx.Err()
+type Progress struct {
+	ctx   context.Context
+	fn    ProgressFunc
+	total int64
+
+	mu   sync.Mutex
+	done int64
+}
+
+// NewProgress fn 可以为空, 此时只检查 ctx
+func NewProgress(ctx context.Context, total int64, fn ProgressFunc) *Progress {
+	return &Progress{ctx: ctx, fn: fn, total: total}
+}
+
+// Add 增加 n 字节的进度
+func (p *Progress) Add(n int64) {
+	if p.fn == nil || n == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	p.fn(p.done, p.total)
+}
+
+// Err 返回 ctx.Err()
+func (p *Progress) Err() error {
+	return p.ctx.Err()
+}
+
+// Reader 返回统计读取字节数的 reader, r 实现了 io.Seeker 时返回 io.ReadSeeker, 重复读取的数据不计入进度
+func (p *Progress) Reader(r io.Reader) io.Reader {
+	pr := &progressReader{p: p, r: r}
+	if s, ok := r.(io.Seeker); ok {
+		return &progressReadSeeker{progressReader: pr, s: s}
+	}
+	return pr
+}
+
+type progressReader struct {
+	p        *Progress
+	r        io.Reader
+	pos, max int64
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	if err := r.p.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(b)
+	r.pos += int64(n)
+	if r.pos > r.max {
+		r.p.Add(r.pos - r.max)
+		r.max = r.pos
+	}
+	return n, err
+}
+
+type progressReadSeeker struct {
+	*progressReader
+	s io.Seeker
+}
+
+func (r *progressReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.s.Seek(offset, whence)
+	if err == nil {
+		r.pos = pos
+	}
+	return pos, err
+}
@@ -0,0 +1,171 @@
+package godb
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+
+	"github.com/happyxhw/pkg/aes"
+)
+
+/*
+字段加密: FieldCrypto 实现 gorm 的 schema.SerializerInterface, 注册后在字段上使用 `gorm:"serializer:<name>"`.
+写入时使用 keyring 的 active 密钥加密, 读取时按密文中的 key id 解密, 数据库中保存 base64 编码的 aes.Keyring 密文.
+string 和 []byte 字段直接加密, 其他类型 JSON 编码后加密. 密钥轮换后重新保存记录即可使用新密钥.
+
+表名, 列名和主键值作为 additional data 参与认证, 密文复制到其他行或列时无法解密:
+  - 写入前需要确定主键, 不能依赖数据库自增; 没有主键的表只绑定表名和列名
+  - 读取时主键列需要在加密列之前扫描, 例如主键是第一列时的 SELECT *
+
+加密字段无法查询, 需要等值查询时增加保存 BlindIndex 的列:
+
+	type User struct {
+		ID         int64
+		Email      string `gorm:"serializer:encrypted"`
+		EmailIndex string `gorm:"index"`
+	}
+
+	fc := godb.NewFieldCrypto(keyring, indexKey)
+	fc.Register("encrypted")
+	index, err := fc.BlindIndex(email)
+	u.Email, u.EmailIndex = email, index
+	db.Where("email_index = ?", index).First(&u)
+*/
+
+var (
+	// ErrNoIndexKey 未设置盲索引的 key
+	ErrNoIndexKey = errors.New("blind index key not set")
+	// ErrNoPrimaryKey 读写加密字段时主键为零值
+	ErrNoPrimaryKey = errors.New("primary key not set")
+)
+
+// FieldCrypto 字段加密的 keyring 和盲索引的 HMAC key
+type FieldCrypto struct {
+	keyring  *aes.Keyring
+	indexKey []byte
+}
+
+// NewFieldCrypto indexKey 为空时不能使用 BlindIndex.
+// indexKey 不应与加密密钥相同, 更换 indexKey 后需要重新计算所有索引
+func NewFieldCrypto(keyring *aes.Keyring, indexKey []byte) *FieldCrypto {
+	return &FieldCrypto{keyring: keyring, indexKey: indexKey}
+}
+
+// Register 注册为名为 name 的 gorm serializer
+func (c *FieldCrypto) Register(name string) {
+	schema.RegisterSerializer(name, c)
+}
+
+// BlindIndex 返回 hex(HMAC-SHA256(indexKey, plaintext)), 用于写入索引列或查询条件
+func (c *FieldCrypto) BlindIndex(plaintext string) (string, error) {
+	if len(c.indexKey) == 0 {
+		return "", ErrNoIndexKey
+	}
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(plaintext))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Value 加密字段, nil 指针保存为 NULL
+func (c *FieldCrypto) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	var plaintext []byte
+	switch v := fieldValue.(type) {
+	case string:
+		plaintext = []byte(v)
+	case []byte:
+		if v == nil {
+			return nil, nil
+		}
+		plaintext = v
+	default:
+		if rv := reflect.ValueOf(fieldValue); fieldValue == nil || rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil, nil
+		}
+		var err error
+		if plaintext, err = json.Marshal(fieldValue); err != nil {
+			return nil, err
+		}
+	}
+	ad, err := fieldAD(ctx, field, dst)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := c.keyring.EncryptWithAD(plaintext, ad)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+// Scan 解密字段, 数据库中的值为 NULL 时设置为零值
+func (c *FieldCrypto) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	fieldValue := reflect.New(field.FieldType)
+	if dbValue != nil {
+		plaintext, err := c.decrypt(ctx, field, dst, dbValue)
+		if err != nil {
+			return err
+		}
+		switch v := fieldValue.Interface().(type) {
+		case *string:
+			*v = string(plaintext)
+		case *[]byte:
+			*v = plaintext
+		default:
+			if err = json.Unmarshal(plaintext, v); err != nil {
+				return err
+			}
+		}
+	}
+	field.ReflectValueOf(ctx, dst).Set(fieldValue.Elem())
+	return nil
+}
+
+func (c *FieldCrypto) decrypt(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) ([]byte, error) {
+	var encoded []byte
+	switch v := dbValue.(type) {
+	case string:
+		encoded = []byte(v)
+	case []byte:
+		encoded = v
+	default:
+		return nil, fmt.Errorf("unsupported encrypted field type %T", dbValue)
+	}
+	encrypted := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	n, err := base64.StdEncoding.Decode(encrypted, encoded)
+	if err != nil {
+		return nil, err
+	}
+	ad, err := fieldAD(ctx, field, dst)
+	if err != nil {
+		return nil, err
+	}
+	return c.keyring.DecryptWithAD(encrypted[:n], ad)
+}
+
+// additional data: 表名, 列名和主键值, 各自带 4 字节长度前缀
+func fieldAD(ctx context.Context, field *schema.Field, dst reflect.Value) ([]byte, error) {
+	var ad []byte
+	appendValue := func(s string) {
+		ad = binary.BigEndian.AppendUint32(ad, uint32(len(s)))
+		ad = append(ad, s...)
+	}
+	appendValue(field.Schema.Table)
+	appendValue(field.DBName)
+	if pk := field.Schema.PrioritizedPrimaryField; pk != nil {
+		v, zero := pk.ValueOf(ctx, dst)
+		if zero {
+			return nil, ErrNoPrimaryKey
+		}
+		appendValue(fmt.Sprint(v))
+	}
+	return ad, nil
+}
@@ -0,0 +1,101 @@
+package godb
+
+import (
+	"database/sql/driver"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/happyxhw/pkg/aes"
+	"github.com/happyxhw/pkg/mymock"
+)
+
+type profile struct {
+	Phone string `json:"phone"`
+	Age   int    `json:"age"`
+}
+
+type person struct {
+	ID         int64    `gorm:"column:id"`
+	Email      string   `gorm:"column:email;serializer:encrypted"`
+	EmailIndex string   `gorm:"column:email_index"`
+	Profile    *profile `gorm:"column:profile;serializer:encrypted"`
+}
+
+func (person) TableName() string {
+	return "person"
+}
+
+// 记录写入数据库的参数
+type captureArg struct {
+	value driver.Value
+}
+
+func (c *captureArg) Match(v driver.Value) bool {
+	c.value = v
+	return true
+}
+
+func TestEncryptedField(t *testing.T) {
+	kr := aes.NewKeyring()
+	key, err := aes.GenAesKey(32)
+	require.NoError(t, err)
+	require.NoError(t, kr.Rotate("v1", key))
+	fc := NewFieldCrypto(kr, []byte("index key"))
+	fc.Register("encrypted")
+
+	gdb, mock, err := mymock.MockRegexDB()
+	require.NoError(t, err)
+
+	hash, err := fc.BlindIndex("mock@mock.com")
+	require.NoError(t, err)
+	p := person{
+		ID:         1,
+		Email:      "mock@mock.com",
+		EmailIndex: hash,
+		Profile:    &profile{Phone: "123", Age: 18},
+	}
+	var email, prof captureArg
+	mock.ExpectQuery(`INSERT INTO "person"`).WithArgs(&email, hash, &prof, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	require.NoError(t, gdb.Create(&p).Error)
+	require.NotContains(t, email.value, "mock")
+	require.NotContains(t, prof.value, "123")
+
+	// 轮换密钥后旧数据仍可读取
+	key, err = aes.GenAesKey(32)
+	require.NoError(t, err)
+	require.NoError(t, kr.Rotate("v2", key))
+
+	mock.ExpectQuery(`SELECT \* FROM "person" WHERE email_index = \$1`).
+		WithArgs(hash).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "email_index", "profile"}).
+			AddRow(1, email.value, hash, prof.value))
+	var got person
+	require.NoError(t, gdb.Where("email_index = ?", hash).First(&got).Error)
+	require.Equal(t, p, got)
+
+	// NULL 读取为零值
+	mock.ExpectQuery(`SELECT \* FROM "person"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "email_index", "profile"}).
+			AddRow(1, nil, nil, nil))
+	got = person{}
+	require.NoError(t, gdb.First(&got).Error)
+	require.Equal(t, person{ID: 1}, got)
+
+	// 密文复制到其他行或其他列时无法解密
+	mock.ExpectQuery(`SELECT \* FROM "person"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(2, email.value))
+	require.ErrorIs(t, gdb.First(&person{}).Error, aes.ErrAuth)
+	mock.ExpectQuery(`SELECT \* FROM "person"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "profile"}).AddRow(1, email.value))
+	require.ErrorIs(t, gdb.First(&person{}).Error, aes.ErrAuth)
+
+	// 写入前必须确定主键
+	require.ErrorIs(t, gdb.Create(&person{Email: "mock@mock.com"}).Error, ErrNoPrimaryKey)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	_, err = NewFieldCrypto(kr, nil).BlindIndex("mock@mock.com")
+	require.ErrorIs(t, err, ErrNoIndexKey)
+}
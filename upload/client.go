@@ -0,0 +1,241 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/happyxhw/pkg/fsutil"
+)
+
+const (
+	defaultWorkers    = 4
+	defaultRetries    = 3
+	defaultRetryDelay = 500 * time.Millisecond
+)
+
+// StatusError 服务端返回的错误
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("upload: %d %s", e.Code, e.Message)
+}
+
+// 网络错误, 5xx 和 429 可以重试, 其他 4xx 如 md5 不一致不重试
+func retryable(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code >= 500 || se.Code == http.StatusTooManyRequests
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// Client 断点续传客户端
+type Client struct {
+	URL        string        // 服务端地址, 包含 Register 的 group 路径, 如 https://example.com/uploads
+	Client     *http.Client  // 为空时使用 http.DefaultClient
+	Workers    int           // 并发上传的分片数, 默认 4
+	Retries    int           // 每个请求失败后的重试次数, 默认 3, 小于 0 时不重试
+	RetryDelay time.Duration // 第一次重试前的等待时间, 默认 500ms, 之后每次加倍
+}
+
+// Upload 按 splitSize 切分 in 并上传, 只上传服务端缺少的分片, 返回服务端的 id.
+// progress 可以为空, total 为需要上传的字节数
+func (c *Client) Upload(ctx context.Context, in string, splitSize int64, progress fsutil.ProgressFunc) (string, error) {
+	f, err := fsutil.SplitContext(ctx, in, splitSize, nil)
+	if err != nil {
+		return "", err
+	}
+	return c.UploadFile(ctx, in, f, progress)
+}
+
+// UploadFile 按已经计算好的 manifest(如 fsutil.SplitCDC 的结果)上传 in, 分片数据与 manifest 不一致时服务端拒绝, 不会重试
+func (c *Client) UploadFile(ctx context.Context, in string, f *fsutil.File, progress fsutil.ProgressFunc) (string, error) {
+	var st Status
+	if err := c.retry(ctx, func() error { return c.call(ctx, http.MethodPost, "", f, &st) }); err != nil {
+		return "", err
+	}
+	if st.Done {
+		return st.ID, nil
+	}
+	if err := c.uploadParts(ctx, in, f, &st, progress); err != nil {
+		return "", err
+	}
+	if err := c.retry(ctx, func() error { return c.call(ctx, http.MethodPost, "/"+st.ID+"/complete", nil, &st) }); err != nil {
+		return "", err
+	}
+	return st.ID, nil
+}
+
+// Status 查询上传状态
+func (c *Client) Status(ctx context.Context, id string) (*Status, error) {
+	var st Status
+	if err := c.call(ctx, http.MethodGet, "/"+id, nil, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (c *Client) uploadParts(ctx context.Context, in string, f *fsutil.File, st *Status, progress fsutil.ProgressFunc) error {
+	file, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var total int64
+	for _, i := range st.Missing {
+		if i < 0 || i >= len(f.Parts) {
+			return fmt.Errorf("upload: invalid part index %d", i)
+		}
+		total += f.Parts[i].Size
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	p := fsutil.NewProgress(ctx, total, progress)
+
+	workers := c.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	if workers > len(st.Missing) {
+		workers = len(st.Missing)
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		next     int64 = -1
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				n := int(atomic.AddInt64(&next, 1))
+				if n >= len(st.Missing) {
+					return
+				}
+				i := st.Missing[n]
+				part := f.Parts[i]
+				// 重试时 seek 到开头, 重复读取的数据不计入进度
+				r := p.Reader(io.NewSectionReader(file, part.Offset, part.Size)).(io.ReadSeeker)
+				err := c.retry(ctx, func() error {
+					if _, err := r.Seek(0, io.SeekStart); err != nil {
+						return err
+					}
+					return c.putPart(ctx, st.ID, i, r, part.Size)
+				})
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return p.Err()
+}
+
+func (c *Client) putPart(ctx context.Context, id string, i int, r io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.url(fmt.Sprintf("/%s/parts/%d", id, i)), io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return c.do(req, nil)
+}
+
+func (c *Client) call(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path), body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.do(req, out)
+}
+
+func (c *Client) do(req *http.Request, out any) error {
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		return &StatusError{Code: resp.StatusCode, Message: e.Message}
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) url(path string) string {
+	return strings.TrimSuffix(c.URL, "/") + path
+}
+
+// 失败后等待 RetryDelay 重试, 每次加倍
+func (c *Client) retry(ctx context.Context, fn func() error) error {
+	retries := c.Retries
+	if retries == 0 {
+		retries = defaultRetries
+	}
+	delay := c.RetryDelay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+	for i := 0; ; i++ {
+		err := fn()
+		if err == nil || i >= retries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,288 @@
+// Package upload: 基于 fsutil.File 的断点续传
+package upload
+
+import (
+	"crypto/md5" //nolint:gosec
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/happyxhw/pkg/fsutil"
+)
+
+/*
+断点续传协议, 路径相对于 Register 的 group:
+	POST /                   body 为 fsutil.File 的 json      -> Status, 服务端缺少的分片
+	GET  /:id                                                 -> Status
+	PUT  /:id/parts/:index   body 为分片数据, 校验大小, md5 和 sha256(如有)   -> 204
+	POST /:id/complete       合并分片并校验整个文件的 md5      -> Status, Done 为 true
+id 由 manifest 计算, 同一文件重复上传时得到相同的 id, 已经合并的文件不需要再次上传.
+manifest 最大 16MB, 最多 65536 个分片, 除空文件外分片大小必须大于 0.
+失败时返回 echo 的错误格式 {"message": "..."}
+*/
+
+const (
+	maxManifestSize  = 16 << 20
+	maxManifestParts = 1 << 16
+)
+
+// Status 上传状态
+type Status struct {
+	ID      string `json:"id"`
+	Missing []int  `json:"missing"` // 服务端缺少的分片序号
+	Done    bool   `json:"done"`    // 已经合并
+}
+
+// Server 断点续传服务端, 分片保存在 Dir/<id>.parts/, 合并后的文件为 Dir/<id>
+type Server struct {
+	Dir     string
+	MaxSize int64 // 允许上传的最大文件大小, 0 不限制
+
+	mu sync.Mutex // 合并时加锁
+}
+
+// NewServer 创建服务端, dir 不存在时自动创建
+func NewServer(dir string) (*Server, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Server{Dir: dir}, nil
+}
+
+// Register 注册路由, 如 s.Register(e.Group("/uploads"))
+func (s *Server) Register(g *echo.Group) {
+	g.POST("", s.create)
+	g.GET("/:id", s.status)
+	g.PUT("/:id/parts/:index", s.putPart)
+	g.POST("/:id/complete", s.complete)
+}
+
+// Path 合并后的文件路径, 文件不一定存在
+func (s *Server) Path(id string) string {
+	return filepath.Join(s.Dir, id)
+}
+
+func (s *Server) partsDir(id string) string {
+	return filepath.Join(s.Dir, id+".parts")
+}
+
+func (s *Server) create(c echo.Context) error {
+	var f fsutil.File
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, maxManifestSize)
+	if err := json.NewDecoder(body).Decode(&f); err != nil {
+		var me *http.MaxBytesError
+		if errors.As(err, &me) {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "manifest too large")
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if s.MaxSize > 0 && f.Size > s.MaxSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "file too large")
+	}
+	if len(f.Parts) > maxManifestParts {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "too many parts")
+	}
+	if err := checkManifest(&f); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	// 服务端不使用客户端的路径
+	f.Path = filepath.Base(f.Path)
+	id := manifestID(&f)
+	if !fsutil.IsFile(s.Path(id)) {
+		dir := s.partsDir(id)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if !fsutil.IsFile(filepath.Join(dir, fsutil.ManifestName)) {
+			data, err := json.Marshal(&f)
+			if err != nil {
+				return err
+			}
+			if err = fsutil.WriteFileAtomic(filepath.Join(dir, fsutil.ManifestName), data); err != nil {
+				return err
+			}
+		}
+	}
+	st, err := s.getStatus(id, &f)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, st)
+}
+
+func (s *Server) status(c echo.Context) error {
+	id, f, err := s.manifest(c)
+	if err != nil {
+		return err
+	}
+	st, err := s.getStatus(id, f)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, st)
+}
+
+func (s *Server) putPart(c echo.Context) error {
+	id, f, err := s.manifest(c)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		return echo.NewHTTPError(http.StatusConflict, "upload already completed")
+	}
+	i, err := strconv.Atoi(c.Param("index"))
+	if err != nil || i < 0 || i >= len(f.Parts) {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid part index")
+	}
+	part := f.Parts[i]
+
+	md5h, shah := md5.New(), sha256.New() //nolint:gosec
+	r := io.TeeReader(io.LimitReader(c.Request().Body, part.Size+1), io.MultiWriter(md5h, shah))
+	pf, err := fsutil.CreateAtomic(filepath.Join(s.partsDir(id), fsutil.PartName(i)))
+	if err != nil {
+		return err
+	}
+	defer pf.Close()
+	n, err := io.Copy(pf, r)
+	if err != nil {
+		return err
+	}
+	if n != part.Size || hex.EncodeToString(md5h.Sum(nil)) != part.MD5 ||
+		(part.SHA256 != "" && hex.EncodeToString(shah.Sum(nil)) != part.SHA256) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("part %d: size or hash mismatch", i))
+	}
+	if err = pf.Commit(); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *Server) complete(c echo.Context) error {
+	id, f, err := s.manifest(c)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		return c.JSON(http.StatusOK, &Status{ID: id, Missing: []int{}, Done: true})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 等待锁时可能已经被合并
+	if fsutil.IsFile(s.Path(id)) {
+		return c.JSON(http.StatusOK, &Status{ID: id, Missing: []int{}, Done: true})
+	}
+	dir := s.partsDir(id)
+	if _, err = fsutil.JoinDir(dir, s.Path(id)); err != nil {
+		var je *fsutil.JoinError
+		switch {
+		case errors.As(err, &je):
+			return echo.NewHTTPError(http.StatusConflict, je.Error())
+		case errors.Is(err, fsutil.ErrMD5Mismatch):
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		default:
+			return err
+		}
+	}
+	if err = os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &Status{ID: id, Missing: []int{}, Done: true})
+}
+
+// 读取 id 对应的 manifest, 已经合并时 f 为空
+func (s *Server) manifest(c echo.Context) (string, *fsutil.File, error) {
+	id := c.Param("id")
+	if !validID(id) {
+		return "", nil, echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+	if fsutil.IsFile(s.Path(id)) {
+		return id, nil, nil
+	}
+	f, err := fsutil.ReadManifest(filepath.Join(s.partsDir(id), fsutil.ManifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil, echo.NewHTTPError(http.StatusNotFound, "upload not found")
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return id, f, nil
+}
+
+// 分片在写入时已经校验, 这里只检查大小
+func (s *Server) getStatus(id string, f *fsutil.File) (*Status, error) {
+	st := Status{ID: id, Missing: []int{}}
+	if f == nil || fsutil.IsFile(s.Path(id)) {
+		st.Done = true
+		return &st, nil
+	}
+	dir := s.partsDir(id)
+	for i, p := range f.Parts {
+		fi, err := os.Stat(filepath.Join(dir, fsutil.PartName(i)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err != nil || fi.Size() != p.Size {
+			st.Missing = append(st.Missing, i)
+		}
+	}
+	return &st, nil
+}
+
+// 检查 manifest 的分片和哈希格式, 旧的 manifest 可以没有 sha256
+func checkManifest(f *fsutil.File) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	if !validMD5(f.MD5) {
+		return errors.New("invalid md5")
+	}
+	for _, p := range f.Parts {
+		// 只有空文件有一个大小为 0 的分片
+		if p.Size == 0 && (f.Size > 0 || len(f.Parts) > 1) {
+			return errors.New("empty part")
+		}
+		if !validMD5(p.MD5) {
+			return errors.New("invalid part md5")
+		}
+		if p.SHA256 != "" && !validID(p.SHA256) {
+			return errors.New("invalid part sha256")
+		}
+	}
+	return nil
+}
+
+// id 为 manifest 的 sha256, 与客户端的路径和修改时间无关. 分片没有 sha256 时与旧的 id 相同
+func manifestID(f *fsutil.File) string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s %d %d\n", f.MD5, f.Size, f.SplitSize)
+	for _, p := range f.Parts {
+		if p.SHA256 == "" {
+			_, _ = fmt.Fprintf(h, "%d %d %s\n", p.Offset, p.Size, p.MD5)
+		} else {
+			_, _ = fmt.Fprintf(h, "%d %d %s %s\n", p.Offset, p.Size, p.MD5, p.SHA256)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// id 和分片的 sha256 均为 64 位 hex
+func validID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == sha256.Size
+}
+
+func validMD5(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == md5.Size
+}
@@ -0,0 +1,219 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/happyxhw/pkg/fsutil"
+)
+
+func TestUpload(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewServer(filepath.Join(dir, "srv"))
+	require.NoError(t, err)
+
+	// 统计上传的分片, fail 返回 true 时返回 500
+	var puts int64
+	var fail atomic.Value
+	fail.Store(func(path string) bool { return false })
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().Method == http.MethodPut {
+				if fail.Load().(func(string) bool)(c.Request().URL.Path) {
+					return echo.NewHTTPError(http.StatusInternalServerError, "injected")
+				}
+				atomic.AddInt64(&puts, 1)
+			}
+			return next(c)
+		}
+	})
+	s.Register(e.Group("/uploads"))
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	in := filepath.Join(dir, "in")
+	data := make([]byte, 10*1024+7)
+	_, err = io.ReadFull(rand.Reader, data)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(in, data, 0600))
+
+	// 分片 3 一直失败, 之前的分片保存在服务端
+	fail.Store(func(path string) bool { return strings.HasSuffix(path, "/parts/3") })
+	c := &Client{URL: srv.URL + "/uploads", Retries: 2, Workers: 1, RetryDelay: time.Millisecond}
+	ctx := context.Background()
+	_, err = c.Upload(ctx, in, 1024, nil)
+	var se *StatusError
+	require.ErrorAs(t, err, &se)
+	require.Equal(t, http.StatusInternalServerError, se.Code)
+
+	f, err := fsutil.Split(in, 1024)
+	require.NoError(t, err)
+	id := manifestID(f)
+	st, err := c.Status(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, []int{3, 4, 5, 6, 7, 8, 9, 10}, st.Missing)
+
+	// 分片 5 第一次失败, 重试后成功; 只上传缺少的分片
+	var once int32
+	fail.Store(func(path string) bool {
+		return strings.HasSuffix(path, "/parts/5") && atomic.CompareAndSwapInt32(&once, 0, 1)
+	})
+	atomic.StoreInt64(&puts, 0)
+	c.Workers = 4
+	var done, total int64
+	got, err := c.Upload(ctx, in, 1024, func(d, t int64) { done, total = d, t })
+	require.NoError(t, err)
+	require.Equal(t, id, got)
+	require.Equal(t, int64(len(st.Missing)), atomic.LoadInt64(&puts))
+	require.Equal(t, total, done)
+	data2, err := os.ReadFile(s.Path(id))
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(data, data2))
+	_, err = os.Stat(s.partsDir(id))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// 已经合并的文件不再上传
+	atomic.StoreInt64(&puts, 0)
+	_, err = c.Upload(ctx, in, 1024, nil)
+	require.NoError(t, err)
+	require.Zero(t, atomic.LoadInt64(&puts))
+}
+
+func TestUploadCDC(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewServer(filepath.Join(dir, "srv"))
+	require.NoError(t, err)
+	e := echo.New()
+	s.Register(e.Group("/uploads"))
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	in := filepath.Join(dir, "in")
+	data := make([]byte, 64*1024)
+	_, err = io.ReadFull(rand.Reader, data)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(in, data, 0600))
+	f, err := fsutil.SplitCDC(in, fsutil.NewCDCOpt(4096))
+	require.NoError(t, err)
+
+	c := &Client{URL: srv.URL + "/uploads"}
+	id, err := c.UploadFile(context.Background(), in, f, nil)
+	require.NoError(t, err)
+	data2, err := os.ReadFile(s.Path(id))
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(data, data2))
+
+	// sha256 不一致时拒绝
+	f.Parts[0].SHA256 = strings.Repeat("0", 64)
+	_, err = c.UploadFile(context.Background(), in, f, nil)
+	var se *StatusError
+	require.ErrorAs(t, err, &se)
+	require.Equal(t, http.StatusUnprocessableEntity, se.Code)
+}
+
+func TestUploadRejectCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewServer(filepath.Join(dir, "srv"))
+	require.NoError(t, err)
+	e := echo.New()
+	s.Register(e.Group("/uploads"))
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	in := filepath.Join(dir, "in")
+	require.NoError(t, os.WriteFile(in, bytes.Repeat([]byte("a"), 3000), 0600))
+	f, err := fsutil.Split(in, 1024)
+	require.NoError(t, err)
+
+	// 文件在切分后被修改, 服务端拒绝且不重试
+	require.NoError(t, os.WriteFile(in, bytes.Repeat([]byte("b"), 3000), 0600))
+	c := &Client{URL: srv.URL + "/uploads"}
+	_, err = c.UploadFile(context.Background(), in, f, nil)
+	var se *StatusError
+	require.True(t, errors.As(err, &se))
+	require.Equal(t, http.StatusUnprocessableEntity, se.Code)
+
+	// 分片未上传完时不能合并
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/uploads/"+manifestID(f)+"/complete", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	_, err = c.Status(context.Background(), "../../etc")
+	require.ErrorAs(t, err, &se)
+	f.Parts[0].Size++
+	_, err = c.UploadFile(context.Background(), in, f, nil)
+	require.ErrorAs(t, err, &se)
+	require.Equal(t, http.StatusBadRequest, se.Code)
+}
+
+func TestUploadRejectManifest(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewServer(filepath.Join(dir, "srv"))
+	require.NoError(t, err)
+	s.MaxSize = 1 << 20
+	e := echo.New()
+	s.Register(e.Group("/uploads"))
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	post := func(body []byte) int {
+		resp, err := http.Post(srv.URL+"/uploads", "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	manifest := func(f *fsutil.File) []byte {
+		data, err := json.Marshal(f)
+		require.NoError(t, err)
+		return data
+	}
+	md5Empty := "d41d8cd98f00b204e9800998ecf8427e"
+
+	require.Equal(t, http.StatusRequestEntityTooLarge, post(bytes.Repeat([]byte(" "), maxManifestSize+1)))
+	require.Equal(t, http.StatusRequestEntityTooLarge, post(manifest(&fsutil.File{MD5: md5Empty, Size: 2 << 20})))
+
+	// 分片数超过限制
+	f := &fsutil.File{MD5: md5Empty, Size: maxManifestParts + 1}
+	for i := 0; i <= maxManifestParts; i++ {
+		f.Parts = append(f.Parts, &fsutil.Part{MD5: md5Empty, Size: 1, Offset: int64(i)})
+	}
+	require.Equal(t, http.StatusRequestEntityTooLarge, post(manifest(f)))
+
+	// 非空文件中大小为 0 的分片
+	f = &fsutil.File{MD5: md5Empty, Size: 1, Parts: []*fsutil.Part{
+		{MD5: md5Empty, Size: 0, Offset: 0},
+		{MD5: md5Empty, Size: 1, Offset: 0},
+	}}
+	require.Equal(t, http.StatusBadRequest, post(manifest(f)))
+	f = &fsutil.File{MD5: md5Empty, Parts: []*fsutil.Part{{MD5: md5Empty}, {MD5: md5Empty}}}
+	require.Equal(t, http.StatusBadRequest, post(manifest(f)))
+
+	// 空文件只有一个大小为 0 的分片
+	in := filepath.Join(dir, "in")
+	require.NoError(t, os.WriteFile(in, nil, 0600))
+	c := &Client{URL: srv.URL + "/uploads"}
+	id, err := c.Upload(context.Background(), in, 1024, nil)
+	require.NoError(t, err)
+	data, err := os.ReadFile(s.Path(id))
+	require.NoError(t, err)
+	require.Empty(t, data)
+}